@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often to save the checkpoint while walking.
+const checkpointInterval = time.Minute
+
+// First line of the checkpoint files, to identify them (and their version).
+const checkpointHeader = "summer checkpoint v1"
+
+// Progress of a walk, so it can be resumed if interrupted (see -checkpoint).
+//
+// Roots on different devices are walked at the same time (see walk), so we
+// keep track of each root separately.
+//
+// Files are sent to the workers in walk order, but they can finish in any
+// order. So for each root we keep track of the ones sent but not finished yet,
+// and consider done everything up to the one before the first pending file.
+//
+// filepath.WalkDir walks each root in lexical order, so to resume we skip the
+// finished roots, and within the others, the files and directories that come
+// before the last file done (see walkLess).
+type checkpoint struct {
+	// File to save the checkpoint to ("" = don't save it).
+	file  string
+	roots []string
+
+	mu sync.Mutex
+
+	// Progress of each root, in the same order as roots.
+	progress []rootProgress
+
+	// Position of each root we are resuming from. Everything up to it is
+	// skipped.
+	resume []rootProgress
+}
+
+// Progress within a root.
+type rootProgress struct {
+	pending []pendingFile
+	nextSeq int64
+
+	// Path up to which everything is done.
+	done walkPos
+
+	// The whole root was walked, and everything in it is done.
+	walked, finished bool
+}
+
+// A file sent to the workers.
+type pendingFile struct {
+	path     string
+	finished bool
+}
+
+// Position within the walk of a root.
+type walkPos struct {
+	valid bool
+	path  string
+}
+
+// Return a new checkpoint, loading the given file to resume from it, if it
+// exists. Checkpoints for a different set of roots are ignored.
+func newCheckpoint(file string, roots []string) (*checkpoint, error) {
+	c := &checkpoint{
+		file:     file,
+		roots:    roots,
+		progress: make([]rootProgress, len(roots)),
+		resume:   make([]rootProgress, len(roots)),
+	}
+	if file == "" {
+		return c, nil
+	}
+
+	savedRoots, resume, err := loadCheckpoint(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading checkpoint: %w", err)
+	}
+
+	// These notes are not part of the JSON output.
+	notef := Printf
+	if options.format == formatJSONL {
+		notef = func(string, ...interface{}) {}
+	}
+
+	if !slices.Equal(savedRoots, roots) {
+		notef("checkpoint %q is for different paths, ignoring it", file)
+		return c, nil
+	}
+
+	for i, r := range resume {
+		c.resume[i] = r
+		c.progress[i].done = r.done
+		c.progress[i].finished = r.finished
+		if r.finished {
+			notef("resuming from checkpoint %q, skipping %q", file, roots[i])
+		} else if r.done.valid {
+			notef("resuming from checkpoint %q, skipping up to %q",
+				file, r.done.path)
+		}
+	}
+	return c, nil
+}
+
+// Should the whole root be skipped, because it was already done?
+func (c *checkpoint) skipRoot(root int) bool {
+	return c.resume[root].finished
+}
+
+// Should the path be skipped, because it was already done?
+func (c *checkpoint) skip(root int, path string, isDir bool) bool {
+	r := c.resume[root]
+	if r.finished {
+		return true
+	}
+	if !r.done.valid {
+		return false
+	}
+
+	if isDir {
+		// Directories are skipped only if we are done with all their
+		// contents. The root itself is never skipped, see skipRoot.
+		return path != c.roots[root] && walkLess(path, r.done.path) &&
+			!strings.HasPrefix(r.done.path, path+string(os.PathSeparator))
+	}
+	return path == r.done.path || walkLess(path, r.done.path)
+}
+
+// Record that the file is being sent to the workers. Returns the sequence
+// number to pass to finish.
+func (c *checkpoint) send(root int, path string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rp := &c.progress[root]
+	rp.pending = append(rp.pending, pendingFile{path: path})
+	rp.nextSeq++
+	return rp.nextSeq - 1
+}
+
+// Record that the file could not be sent after all. It must be the last one
+// passed to send for the root.
+func (c *checkpoint) cancel(root int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rp := &c.progress[root]
+	rp.pending = rp.pending[:len(rp.pending)-1]
+	rp.nextSeq--
+}
+
+// Record that the worker finished with the file.
+func (c *checkpoint) finish(root int, seq int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	rp := &c.progress[root]
+	first := rp.nextSeq - int64(len(rp.pending))
+	rp.pending[seq-first].finished = true
+	for len(rp.pending) > 0 && rp.pending[0].finished {
+		rp.done = walkPos{valid: true, path: rp.pending[0].path}
+		rp.pending = rp.pending[1:]
+	}
+	rp.finished = rp.walked && len(rp.pending) == 0
+}
+
+// Record that the whole root was walked, so once the pending files are
+// finished, the root is done.
+func (c *checkpoint) walkedRoot(root int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rp := &c.progress[root]
+	rp.walked = true
+	rp.finished = len(rp.pending) == 0
+}
+
+// Save the checkpoint to its file. The file is replaced atomically, so it is
+// never left half-written.
+func (c *checkpoint) save() error {
+	if c.file == "" {
+		return nil
+	}
+
+	c.mu.Lock()
+	buf := &strings.Builder{}
+	fmt.Fprintf(buf, "%s\n", checkpointHeader)
+	for _, root := range c.roots {
+		fmt.Fprintf(buf, "root %q\n", root)
+	}
+	for i, rp := range c.progress {
+		if rp.finished {
+			fmt.Fprintf(buf, "finished %d\n", i)
+		} else if rp.done.valid {
+			fmt.Fprintf(buf, "done %d %q\n", i, rp.done.path)
+		}
+	}
+	c.mu.Unlock()
+
+	return writeFileAtomic(c.file, []byte(buf.String()))
+}
+
+// Save the checkpoint periodically, until done is closed. wg is marked done
+// when it returns, so callers can wait for any save in progress to finish.
+func (c *checkpoint) saveEvery(wg *sync.WaitGroup, interval time.Duration, done chan struct{}) {
+	defer wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// Errors are not fatal, and we will try again at the end,
+			// which is when they are reported.
+			c.save()
+		case <-done:
+			return
+		}
+	}
+}
+
+// Remove the checkpoint file, once the walk is complete.
+func (c *checkpoint) remove() error {
+	if c.file == "" {
+		return nil
+	}
+	err := os.Remove(c.file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Load a checkpoint file, returning the roots and the progress of each one.
+func loadCheckpoint(file string) ([]string, []rootProgress, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer fd.Close()
+
+	roots := []string{}
+	progress := map[int]rootProgress{}
+	scanner := bufio.NewScanner(fd)
+	for n := 0; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if n == 0 {
+			if line != checkpointHeader {
+				return nil, nil, fmt.Errorf("%q: unknown format", file)
+			}
+			continue
+		}
+
+		kind, rest, _ := strings.Cut(line, " ")
+		switch kind {
+		case "root":
+			root, err := strconv.Unquote(rest)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%q:%d: %v", file, n+1, err)
+			}
+			roots = append(roots, root)
+		case "done":
+			idx, path, _ := strings.Cut(rest, " ")
+			i, err := strconv.Atoi(idx)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%q:%d: %v", file, n+1, err)
+			}
+			path, err = strconv.Unquote(path)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%q:%d: %v", file, n+1, err)
+			}
+			progress[i] = rootProgress{done: walkPos{valid: true, path: path}}
+		case "finished":
+			i, err := strconv.Atoi(rest)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%q:%d: %v", file, n+1, err)
+			}
+			progress[i] = rootProgress{finished: true}
+		default:
+			return nil, nil, fmt.Errorf("%q:%d: unknown line", file, n+1)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	resume := make([]rootProgress, len(roots))
+	for i, rp := range progress {
+		if i < 0 || i >= len(roots) {
+			return nil, nil, fmt.Errorf("%q: invalid root %d", file, i)
+		}
+		resume[i] = rp
+	}
+	return roots, resume, nil
+}
+
+// Is path a before b in the walk order? filepath.WalkDir visits the entries
+// of each directory in lexical order, and the contents of a directory right
+// after it. So it's a lexical comparison of each path element, which is the
+// same as a lexical comparison of the paths where the separator sorts before
+// any other character.
+func walkLess(a, b string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		ca, cb := a[i], b[i]
+		switch {
+		case ca == cb:
+			continue
+		case ca == os.PathSeparator:
+			return true
+		case cb == os.PathSeparator:
+			return false
+		default:
+			return ca < cb
+		}
+	}
+	return len(a) < len(b)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestWalkLess(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"a/x", "a/y/z", "a-b", "a.c/d", "ab", "b", "B", "a0/1",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0770); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Sorting with walkLess must give the same order as the walk.
+	walked := []string{}
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		walked = append(walked, path)
+		return err
+	})
+
+	sorted := append([]string{}, walked...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return walkLess(sorted[i], sorted[j])
+	})
+	for i := range walked {
+		if walked[i] != sorted[i] {
+			t.Fatalf("order mismatch:\n  walked: %q\n  sorted: %q",
+				walked, sorted)
+		}
+	}
+}
+
+func TestCheckpointFinish(t *testing.T) {
+	c, _ := newCheckpoint("", []string{"r", "q"})
+	s1 := c.send(0, "r/1")
+	s2 := c.send(0, "r/2")
+	s3 := c.send(0, "r/3")
+	q1 := c.send(1, "q/1")
+
+	// Finishing out of order only advances once the earlier ones are done.
+	c.finish(0, s2)
+	if c.progress[0].done.valid {
+		t.Errorf("expected nothing done, got %+v", c.progress[0].done)
+	}
+	c.finish(0, s1)
+	if c.progress[0].done.path != "r/2" {
+		t.Errorf("expected r/2 done, got %+v", c.progress[0].done)
+	}
+
+	// Each root advances on its own.
+	if c.progress[1].done.valid {
+		t.Errorf("expected nothing done in q, got %+v", c.progress[1].done)
+	}
+	c.finish(1, q1)
+	if c.progress[1].done.path != "q/1" {
+		t.Errorf("expected q/1 done, got %+v", c.progress[1].done)
+	}
+
+	// Cancel the last one, and send another in its place.
+	c.cancel(0)
+	s4 := c.send(0, "r/4")
+	if s4 != s3 {
+		t.Errorf("expected seq %d, got %d", s3, s4)
+	}
+
+	// The root is finished once it was walked and nothing is pending.
+	c.walkedRoot(0)
+	if c.progress[0].finished {
+		t.Errorf("expected r not finished, got %+v", c.progress[0])
+	}
+	c.finish(0, s4)
+	if c.progress[0].done.path != "r/4" || len(c.progress[0].pending) != 0 {
+		t.Errorf("expected r/4 done, got %+v", c.progress[0])
+	}
+	if !c.progress[0].finished {
+		t.Errorf("expected r finished, got %+v", c.progress[0])
+	}
+}
+
+func TestCheckpointSaveLoad(t *testing.T) {
+	file := t.TempDir() + "/checkpoint"
+	roots := []string{"A", "dir/B", "C"}
+
+	c, err := newCheckpoint(file, roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.walkedRoot(0)
+	c.finish(1, c.send(1, "dir/B/bad\377name"))
+	if err := c.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = newCheckpoint(file, roots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []rootProgress{
+		{finished: true},
+		{done: walkPos{valid: true, path: "dir/B/bad\377name"}},
+		{},
+	}
+	for i := range expected {
+		if !reflect.DeepEqual(c.resume[i], expected[i]) {
+			t.Errorf("root %d: expected %+v, got %+v",
+				i, expected[i], c.resume[i])
+		}
+	}
+
+	cases := []struct {
+		root  int
+		path  string
+		isDir bool
+		skip  bool
+	}{
+		{0, "A", true, true},
+		{0, "A/x", false, true},
+		{1, "dir/B", true, false},
+		{1, "dir/B/a", false, true},
+		{1, "dir/B/a", true, true},
+		{1, "dir/B/bad\377name", false, true},
+		{1, "dir/B/c", false, false},
+		{1, "dir/B/c", true, false},
+		{2, "C", true, false},
+		{2, "C/a", false, false},
+	}
+	for _, tc := range cases {
+		if got := c.skip(tc.root, tc.path, tc.isDir); got != tc.skip {
+			t.Errorf("skip(%d, %q, %v) = %v, expected %v",
+				tc.root, tc.path, tc.isDir, got, tc.skip)
+		}
+	}
+
+	// A checkpoint for other roots is ignored.
+	c, err = newCheckpoint(file, []string{"A"})
+	if err != nil || c.skipRoot(0) {
+		t.Errorf("expected checkpoint to be ignored, got %+v, %v",
+			c.resume, err)
+	}
+
+	// Invalid files are an error.
+	os.WriteFile(file, []byte("something else\n"), 0660)
+	_, err = newCheckpoint(file, roots)
+	if err == nil {
+		t.Errorf("expected error loading invalid checkpoint")
+	}
+}
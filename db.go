@@ -39,10 +39,25 @@ func (_ XattrDB) Read(f *os.File) (ChecksumV1, error) {
 
 	buf := bytes.NewReader(val)
 	c := ChecksumV1{}
+	if len(val) == binary.Size(checksumV1Legacy{}) {
+		legacy := checksumV1Legacy{}
+		err = binary.Read(buf, binary.LittleEndian, &legacy)
+		c.CRC32C = legacy.CRC32C
+		c.ModTimeUsec = legacy.ModTimeUsec
+		return c, err
+	}
+
 	err = binary.Read(buf, binary.LittleEndian, &c)
 	return c, err
 }
 
+// Checksums written by older versions only had the first two fields of
+// ChecksumV1. We still support reading them.
+type checksumV1Legacy struct {
+	CRC32C      uint32
+	ModTimeUsec int64
+}
+
 func (_ XattrDB) Write(f *os.File, cs ChecksumV1) error {
 	if *dryRun {
 		return nil
@@ -6,6 +6,8 @@ import (
 	"os"
 	"syscall"
 	"testing"
+
+	"golang.org/x/sys/unix"
 )
 
 // Tests for errors that are not feasible to cover by the end to end tests.
@@ -14,8 +16,11 @@ import (
 // test.
 
 func init() {
-	// Initialize the subset options, since they are used as part of the walk.
+	// Initialize the subset, state and throttle options, since they are used
+	// as part of the walk.
 	options.subset, _ = NewSubset()
+	options.state, _ = newStateDB("")
+	options.throttle = newThrottle(0, 0)
 }
 
 func TestDBReadError(t *testing.T) {
@@ -54,12 +59,89 @@ func (f fakeDirEntry) Info() (os.FileInfo, error) {
 }
 
 func TestOpenAndInfoError(t *testing.T) {
-	ok, _, _, err := openAndInfo("fake", fakeDirEntry{}, nil, 0)
+	ok, _, _, err := openAndInfo(".", "fake", fakeDirEntry{}, nil, 0)
 	if !ok || err != testErr {
 		t.Fatalf("expected ok, testErr, got %v, %v", ok, err)
 	}
 }
 
+// DirEntry that returns a fixed fs.FileInfo.
+type infoDirEntry struct {
+	info fs.FileInfo
+}
+
+func (e infoDirEntry) Name() string {
+	return e.info.Name()
+}
+
+func (e infoDirEntry) IsDir() bool {
+	return e.info.IsDir()
+}
+
+func (e infoDirEntry) Type() fs.FileMode {
+	return e.info.Mode().Type()
+}
+
+func (e infoDirEntry) Info() (os.FileInfo, error) {
+	return e.info, nil
+}
+
+func TestOpenAndInfoReplaced(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/file"
+	if err := os.WriteFile(path, []byte("marola\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := infoDirEntry{info}
+
+	// Replace the file with a different one.
+	if err := os.WriteFile(dir+"/other", []byte("trova\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(dir+"/other", path); err != nil {
+		t.Fatal(err)
+	}
+
+	ok, fd, _, err := openAndInfo(dir, path, d, nil, 0)
+	if ok || fd != nil || err != errReplaced {
+		t.Errorf("expected !ok, nil, errReplaced, got %v, %v, %v",
+			ok, fd, err)
+	}
+
+	// Replace the file with a symlink.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/dev/null", path); err != nil {
+		t.Fatal(err)
+	}
+
+	ok, fd, _, err = openAndInfo(dir, path, d, nil, 0)
+	if ok || fd != nil || err != errReplaced {
+		t.Errorf("expected !ok, nil, errReplaced, got %v, %v, %v",
+			ok, fd, err)
+	}
+
+	// Replace the file with a FIFO. Opening it must not block waiting for
+	// a writer.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Mkfifo(path, 0660); err != nil {
+		t.Fatal(err)
+	}
+
+	ok, fd, _, err = openAndInfo(dir, path, d, nil, 0)
+	if ok || fd != nil || err != errReplaced {
+		t.Errorf("expected !ok, nil, errReplaced, got %v, %v, %v",
+			ok, fd, err)
+	}
+}
+
 type fakeDB struct {
 	hasAttr bool
 	hasErr  error
@@ -107,7 +189,7 @@ func TestWalkingFunctionsHandleDBErrors(t *testing.T) {
 		{update, fakeDB{hasAttr: true, readErr: testErr}, testErr},
 	}
 
-	p := NewProgress(false)
+	p := NewProgress(false, workTotals{})
 
 	for _, c := range cases {
 		f, err := os.Open("/dev/null")
@@ -4,7 +4,6 @@ go 1.23.0
 
 require (
 	github.com/pkg/xattr v0.4.10
+	golang.org/x/sys v0.31.0
 	golang.org/x/term v0.30.0
 )
-
-require golang.org/x/sys v0.31.0 // indirect
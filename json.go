@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// Output formats.
+const (
+	// Human-readable text.
+	formatText = "text"
+
+	// JSON lines: one JSON object per event, for machine consumption.
+	formatJSONL = "jsonl"
+)
+
+// Event about a file, for the JSON lines output.
+type jsonEvent struct {
+	// Kind of event: matched, modified, preserved_mtime, new, corrupted,
+	// suspect, violation, unreadable, changed, error.
+	Event string `json:"event"`
+
+	// Path of the file. JSON strings must be valid UTF-8, so if the path is
+	// not, invalid bytes are replaced, and the original path is given
+	// base64-encoded in PathB64.
+	Path    string `json:"path"`
+	PathB64 string `json:"path_b64,omitempty"`
+
+	// Saved and computed checksums, when available.
+	Old *jsonChecksum `json:"old,omitempty"`
+	New *jsonChecksum `json:"new,omitempty"`
+
+	// How long it took to process the file, in seconds.
+	DurationSec float64 `json:"duration_sec,omitempty"`
+
+	// For corrupted files, how many times they were re-read, and how many
+	// of those gave a different checksum than the first read.
+	Rereads       int `json:"rereads,omitempty"`
+	RereadsDiffer int `json:"rereads_differ,omitempty"`
+
+	// For unreadable files, the ranges that could not be read.
+	Ranges []byteRange `json:"unreadable_ranges,omitempty"`
+
+	// For errors, their class and description.
+	Class string `json:"class,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type jsonChecksum struct {
+	CRC32C    string `json:"crc32c"`
+	MtimeUsec int64  `json:"mtime_usec"`
+	Size      int64  `json:"size"`
+}
+
+func newJSONChecksum(cs ChecksumV1) *jsonChecksum {
+	return &jsonChecksum{
+		CRC32C:    fmt.Sprintf("%08x", cs.CRC32C),
+		MtimeUsec: cs.ModTimeUsec,
+		Size:      cs.Size,
+	}
+}
+
+// Return the path to use in JSON strings, and if it is not valid UTF-8, the
+// original path base64-encoded. See jsonEvent.Path for more details.
+func jsonPath(path string) (string, string) {
+	if utf8.ValidString(path) {
+		return path, ""
+	}
+	return strings.ToValidUTF8(path, "\uFFFD"),
+		base64.StdEncoding.EncodeToString([]byte(path))
+}
+
+// Summary of the run, for the JSON lines output (where it is always the last
+// object written), and JSON reports.
+type jsonSummary struct {
+	Event       string  `json:"event,omitempty"`
+	DurationSec float64 `json:"duration_sec"`
+
+	jsonCounters
+
+	// Exit code, and messages describing the problems found (if any).
+	ExitCode int      `json:"exit_code"`
+	Messages []string `json:"messages,omitempty"`
+
+	// Whether it ran at idle priority (see -idle).
+	IdlePriority bool `json:"idle_priority,omitempty"`
+}
+
+// Status of a run in progress, written on request (see statusSignals).
+type jsonStatus struct {
+	Event       string  `json:"event"`
+	DurationSec float64 `json:"duration_sec"`
+
+	jsonCounters
+
+	BytesRead int64          `json:"bytes_read"`
+	InFlight  []jsonInFlight `json:"in_flight"`
+}
+
+// A file in progress.
+type jsonInFlight struct {
+	Path        string  `json:"path"`
+	PathB64     string  `json:"path_b64,omitempty"`
+	Size        int64   `json:"size"`
+	BytesRead   int64   `json:"bytes_read"`
+	DurationSec float64 `json:"duration_sec"`
+}
+
+// Counters of a run, common to the summary and status objects.
+type jsonCounters struct {
+	Matched        int64 `json:"matched"`
+	Modified       int64 `json:"modified"`
+	PreservedMtime int64 `json:"preserved_mtime"`
+	New            int64 `json:"new"`
+	Corrupted      int64 `json:"corrupted"`
+	Suspect        int64 `json:"suspect"`
+	Violations     int64 `json:"violations"`
+	Unreadable     int64 `json:"unreadable"`
+	Changed        int64 `json:"changed"`
+	Errors         int64 `json:"errors"`
+}
+
+func writeJSON(v interface{}) {
+	err := json.NewEncoder(os.Stdout).Encode(v)
+	if err != nil {
+		// We control the types, it should never fail.
+		panic(err)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Amount of work to do, as found by prescan.
+type workTotals struct {
+	files, bytes int64
+}
+
+func (t *workTotals) add(size int64) {
+	t.files++
+	t.bytes += size
+}
+
+func (t workTotals) String() string {
+	return fmt.Sprintf("%d files, %s", t.files, humanBytes(t.bytes))
+}
+
+// Result of the prescan: the total amount of work, and how it is split
+// between roots and devices.
+type prescanResult struct {
+	total   workTotals
+	roots   map[string]*workTotals
+	devices map[deviceID]*workTotals
+}
+
+// Walk the roots without opening the files, to find out how much work there
+// is to do. It uses the same criteria as walk to decide which files to
+// process (see selectFile), except for the subset percentage, which is random
+// and so it can only be approximated. Errors are ignored, they will be found
+// (and reported) by the real walk. It stops early if stopped is closed (it
+// can be nil), in which case the totals are incomplete.
+func prescan(roots []string, stopped <-chan struct{}) *prescanResult {
+	r := &prescanResult{
+		roots:   map[string]*workTotals{},
+		devices: map[deviceID]*workTotals{},
+	}
+	for _, root := range roots {
+		if isClosed(stopped) {
+			break
+		}
+		rootDev := getDeviceForPath(root)
+		rt := &workTotals{}
+		r.roots[root] = rt
+
+		filepath.WalkDir(root,
+			func(path string, d fs.DirEntry, err error) error {
+				if isClosed(stopped) {
+					return errInterrupted
+				}
+				ok, info, err := selectFile(path, d, err, rootDev)
+				if err == fs.SkipDir {
+					return err
+				}
+				if !ok || err != nil || !options.subset.Includes(root, path) {
+					return nil
+				}
+
+				dev := getDevice(info)
+				if r.devices[dev] == nil {
+					r.devices[dev] = &workTotals{}
+				}
+				r.devices[dev].add(info.Size())
+				rt.add(info.Size())
+				r.total.add(info.Size())
+				return nil
+			})
+	}
+
+	if pct := int64(options.subset.percent); pct < 100 {
+		for _, t := range r.all() {
+			t.files = t.files * pct / 100
+			t.bytes = t.bytes * pct / 100
+		}
+	}
+	return r
+}
+
+// Return all the totals, so they can be adjusted together.
+func (r *prescanResult) all() []*workTotals {
+	all := []*workTotals{&r.total}
+	for _, t := range r.roots {
+		all = append(all, t)
+	}
+	for _, t := range r.devices {
+		all = append(all, t)
+	}
+	return all
+}
+
+// Print the results of the prescan, and an estimate of how long processing
+// would take at the given rate (in bytes per second).
+func (r *prescanResult) print(roots []string, rate float64) {
+	for _, root := range roots {
+		Printf("%q: %v", root, r.roots[root])
+	}
+
+	devs := []deviceID{}
+	for dev := range r.devices {
+		devs = append(devs, dev)
+	}
+	sort.Slice(devs, func(i, j int) bool { return devs[i] < devs[j] })
+	for _, dev := range devs {
+		Printf("device %v: %v", dev, r.devices[dev])
+	}
+
+	Printf("total: %v", r.total)
+	if rate > 0 {
+		d := time.Duration(float64(r.total.bytes) / rate * float64(time.Second))
+		Printf("would take ~%v at %s/s", d.Round(time.Second),
+			humanBytes(int64(rate)))
+	}
+}
+
+// Implements the "plan" command: prescan the roots, and print the results.
+func plan(roots []string) {
+	r := prescan(roots, nil)
+	r.print(roots, float64(options.planRate)*1024*1024)
+}
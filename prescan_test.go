@@ -0,0 +1,75 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrescan(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]int{
+		"a":          10,
+		"b":          20,
+		"sub/c":      30,
+		"excluded/d": 40,
+	}
+	for name, size := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0770); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("a", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	oldSubset := options.subset
+	options.subset = &Subset{percent: 100}
+	options.exclude = map[string]bool{filepath.Join(dir, "excluded"): true}
+	defer func() {
+		options.subset = oldSubset
+		options.exclude = nil
+	}()
+
+	sub := filepath.Join(dir, "sub")
+	r := prescan([]string{dir, sub}, nil)
+	check := func(name string, got, expected workTotals) {
+		t.Helper()
+		if got != expected {
+			t.Errorf("%s: expected %+v, got %+v", name, expected, got)
+		}
+	}
+	check("total", r.total, workTotals{files: 4, bytes: 90})
+	check("dir", *r.roots[dir], workTotals{files: 3, bytes: 60})
+	check("sub", *r.roots[sub], workTotals{files: 1, bytes: 30})
+
+	dev := getDeviceForPath(dir)
+	if len(r.devices) != 1 || r.devices[dev] == nil {
+		t.Fatalf("unexpected devices: %v", r.devices)
+	}
+	check("device", *r.devices[dev], workTotals{files: 4, bytes: 90})
+
+	// The subset selection is approximated.
+	options.subset = &Subset{percent: 50}
+	r = prescan([]string{dir}, nil)
+	check("subset total", r.total, workTotals{files: 1, bytes: 30})
+	check("subset dir", *r.roots[dir], workTotals{files: 1, bytes: 30})
+}
+
+func TestPrescanStopped(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("marola\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+
+	stopped := make(chan struct{})
+	close(stopped)
+	r := prescan([]string{dir}, stopped)
+	if r.total != (workTotals{}) {
+		t.Errorf("expected no work after being stopped, got %+v", r.total)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// I/O modes, which control how we read the file contents.
+const (
+	// Regular reads, through the page cache.
+	ioCached = "cached"
+
+	// Drop the file from the page cache before and after reading it, so we
+	// read from the storage, and don't leave our data behind in the cache.
+	ioNoCache = "nocache"
+
+	// Use direct I/O, bypassing the page cache entirely. Falls back to
+	// ioNoCache if not supported by the filesystem.
+	ioDirect = "direct"
+)
+
+// Size of the read buffers, and their alignment. Direct I/O requires both to
+// be multiples of the device's logical block size, so we use values that
+// work for all common devices.
+const (
+	readBufSize  = 256 * 1024
+	readBufAlign = 4096
+)
+
+var readBufPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, readBufSize+readBufAlign)
+		off := int(uintptr(unsafe.Pointer(&buf[0])) % readBufAlign)
+		if off != 0 {
+			off = readBufAlign - off
+		}
+		buf = buf[off : off+readBufSize]
+		return &buf
+	},
+}
+
+// Read the contents of the file, and write them to w. Reads are done
+// according to the I/O mode in the options.
+func readContents(w io.Writer, fd *os.File) error {
+	mode := options.ioMode
+	if mode == ioDirect {
+		if err := setDirectIO(fd); err != nil {
+			// Not supported by this filesystem, fall back.
+			mode = ioNoCache
+		}
+	}
+	if mode == ioNoCache {
+		// Dropping the cache is best-effort, it may not be supported.
+		dropCache(fd)
+		defer dropCache(fd)
+	}
+
+	bufp := readBufPool.Get().(*[]byte)
+	defer readBufPool.Put(bufp)
+	buf := *bufp
+
+	for {
+		n, err := fd.Read(buf)
+		if n > 0 {
+			options.throttle.WaitBytes(n)
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Range of bytes within a file, [Start, End).
+type byteRange struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// Returned when a file could not be read due to I/O errors (usually, media
+// errors).
+type unreadableError struct {
+	// Ranges that could not be read. Can be empty if we could not find them
+	// when re-reading (e.g. if the error was transient).
+	ranges []byteRange
+}
+
+func (e *unreadableError) Error() string {
+	if len(e.ranges) == 0 {
+		return "I/O error, but no unreadable ranges found when re-reading"
+	}
+
+	s := "I/O errors at bytes "
+	for i, r := range e.ranges {
+		if i > 0 {
+			s += ", "
+		}
+		s += fmt.Sprintf("%d-%d", r.Start, r.End-1)
+	}
+	return s
+}
+
+// Find the ranges of the file that can't be read due to I/O errors.
+// Reads are done in big chunks, and the ones that fail are read again block by
+// block, to find the unreadable parts without being too slow on big files.
+// The file may be open for direct I/O, so the offsets and lengths of the reads
+// are always multiples of readBufAlign, even at the end of the file.
+func findUnreadable(r io.ReaderAt, size int64) (*unreadableError, error) {
+	bufp := readBufPool.Get().(*[]byte)
+	defer readBufPool.Put(bufp)
+	buf := *bufp
+
+	ue := &unreadableError{}
+	addBad := func(start, end int64) {
+		last := len(ue.ranges) - 1
+		if last >= 0 && ue.ranges[last].End == start {
+			ue.ranges[last].End = end
+		} else {
+			ue.ranges = append(ue.ranges, byteRange{start, end})
+		}
+	}
+
+	// Read the range [start, start+len(b)), returning true if there was an
+	// I/O error.
+	readAt := func(b []byte, start int64) (bool, error) {
+		_, err := r.ReadAt(b, start)
+		if errors.Is(err, syscall.EIO) {
+			return true, nil
+		} else if err != nil && err != io.EOF {
+			return false, err
+		}
+		return false, nil
+	}
+
+	for off := int64(0); off < size; off += readBufSize {
+		chunkEnd := min(off+readBufSize, size)
+		chunkLen := (chunkEnd - off + readBufAlign - 1) / readBufAlign * readBufAlign
+		bad, err := readAt(buf[:chunkLen], off)
+		if err != nil {
+			return nil, err
+		}
+		if !bad {
+			continue
+		}
+
+		for boff := off; boff < chunkEnd; boff += readBufAlign {
+			end := min(boff+readBufAlign, chunkEnd)
+			bad, err := readAt(buf[:readBufAlign], boff)
+			if err != nil {
+				return nil, err
+			}
+			if bad {
+				addBad(boff, end)
+			}
+		}
+	}
+
+	return ue, nil
+}
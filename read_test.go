@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+// ReaderAt that returns EIO when reading any of the bad ranges. Like files
+// open for direct I/O, it returns EINVAL on unaligned reads.
+type badReaderAt struct {
+	size int64
+	bad  []byteRange
+}
+
+func (r badReaderAt) ReadAt(b []byte, off int64) (int, error) {
+	if off%readBufAlign != 0 || len(b)%readBufAlign != 0 {
+		return 0, syscall.EINVAL
+	}
+	end := off + int64(len(b))
+	for _, br := range r.bad {
+		if off < br.End && end > br.Start {
+			return 0, syscall.EIO
+		}
+	}
+	if end > r.size {
+		return int(max(r.size-off, 0)), io.EOF
+	}
+	return len(b), nil
+}
+
+func TestFindUnreadable(t *testing.T) {
+	cases := []struct {
+		size     int64
+		bad      []byteRange
+		expected []byteRange
+	}{
+		{100, nil, nil},
+		{10 * readBufSize, nil, nil},
+
+		// Small file, all of it bad.
+		{100, []byteRange{{0, 1}}, []byteRange{{0, 100}}},
+
+		// Bad ranges are reported in block units, and contiguous blocks are
+		// merged.
+		{10 * readBufSize,
+			[]byteRange{{5000, 5001}},
+			[]byteRange{{4096, 8192}}},
+		{10 * readBufSize,
+			[]byteRange{{5000, 9000}},
+			[]byteRange{{4096, 12288}}},
+		{10 * readBufSize,
+			[]byteRange{{0, 1}, {readBufSize - 1, readBufSize + 1}},
+			[]byteRange{
+				{0, 4096},
+				{readBufSize - 4096, readBufSize + 4096}}},
+
+		// Partial last block.
+		{readBufSize + 100,
+			[]byteRange{{readBufSize + 50, readBufSize + 51}},
+			[]byteRange{{readBufSize, readBufSize + 100}}},
+	}
+
+	for i, c := range cases {
+		ue, err := findUnreadable(badReaderAt{c.size, c.bad}, c.size)
+		if err != nil {
+			t.Errorf("%d: unexpected error: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(ue.ranges, c.expected) {
+			t.Errorf("%d: expected %v, got %v", i, c.expected, ue.ranges)
+		}
+	}
+}
+
+func TestUnreadableError(t *testing.T) {
+	ue := &unreadableError{}
+	expected := "I/O error, but no unreadable ranges found when re-reading"
+	if ue.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, ue.Error())
+	}
+
+	ue.ranges = []byteRange{{0, 4096}, {8192, 8200}}
+	expected = "I/O errors at bytes 0-4095, 8192-8199"
+	if ue.Error() != expected {
+		t.Errorf("expected %q, got %q", expected, ue.Error())
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// End-of-run report, with the list of problems found.
+type runReport struct {
+	Corrupted  []reportEntry `json:"corrupted"`
+	Suspect    []reportEntry `json:"suspect"`
+	Unreadable []reportEntry `json:"unreadable"`
+	Violations []reportEntry `json:"violations"`
+	Errors     []reportEntry `json:"errors"`
+
+	// Files without checksums (only in strict mode).
+	WithoutChecksums []reportEntry `json:"without_checksums"`
+}
+
+type reportEntry struct {
+	// Path of the file. See jsonEvent.Path for details on PathB64.
+	Path    string `json:"path"`
+	PathB64 string `json:"path_b64,omitempty"`
+
+	// Details about the problem, if any.
+	Detail string `json:"detail,omitempty"`
+
+	// Class of the error (only for errors).
+	Class string `json:"class,omitempty"`
+
+	// Original path, used for sorting and text output.
+	rawPath string
+}
+
+func newReportEntry(path, detail string) reportEntry {
+	e := reportEntry{Detail: detail, rawPath: path}
+	e.Path, e.PathB64 = jsonPath(path)
+	return e
+}
+
+type reportSection struct {
+	title   string
+	entries []reportEntry
+}
+
+func (r *runReport) sections() []reportSection {
+	return []reportSection{
+		{"corrupted files", r.Corrupted},
+		{"suspect files", r.Suspect},
+		{"unreadable files", r.Unreadable},
+		{"policy violations", r.Violations},
+		{"errors", r.Errors},
+		{"files without checksums", r.WithoutChecksums},
+	}
+}
+
+func (r *runReport) sort() {
+	for _, s := range r.sections() {
+		sort.Slice(s.entries, func(i, j int) bool {
+			return s.entries[i].rawPath < s.entries[j].rawPath
+		})
+	}
+}
+
+// Write the report in text format, one section per kind of problem. Empty
+// sections are omitted.
+func (r *runReport) writeText(w io.Writer) {
+	for _, s := range r.sections() {
+		if len(s.entries) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(w, "%s:\n", s.title)
+		for _, e := range s.entries {
+			switch {
+			case e.Class != "":
+				fmt.Fprintf(w, "  %q: %s: %s\n", e.rawPath, e.Class, e.Detail)
+			case e.Detail != "":
+				fmt.Fprintf(w, "  %q: %s\n", e.rawPath, e.Detail)
+			default:
+				fmt.Fprintf(w, "  %q\n", e.rawPath)
+			}
+		}
+	}
+}
+
+// Report, as written to a file in JSON format.
+type jsonReport struct {
+	Start   time.Time   `json:"start"`
+	Summary jsonSummary `json:"summary"`
+	runReport
+}
+
+// Write the report of the run to the given file. If its name ends in
+// ".json", it is written in JSON; otherwise it is written in text.
+func (p *Progress) WriteReport(path string, status *exitStatus) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.report.sort()
+
+	buf := &strings.Builder{}
+	if strings.HasSuffix(path, ".json") {
+		enc := json.NewEncoder(buf)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(jsonReport{
+			Start:     p.start,
+			Summary:   p.summary(status),
+			runReport: p.report,
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintf(buf, "summer report, started %s\n",
+			p.start.Format(time.RFC3339))
+		fmt.Fprintf(buf, "%s\n", p.statusLine())
+		p.report.writeText(buf)
+		for _, msg := range status.msgs {
+			fmt.Fprintf(buf, "%s\n", msg)
+		}
+	}
+
+	return os.WriteFile(path, []byte(buf.String()), 0666)
+}
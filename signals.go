@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Returned by walk's helper when it was stopped by a signal.
+var errInterrupted = errors.New("interrupted, not all files were processed")
+
+// Signals that make us stop gracefully: we stop walking, let the workers
+// finish the files in progress, and then print the summary as usual.
+var stopSignals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+
+// Handle signals while walking. Returns a channel that is closed when one of
+// the stopSignals is received, and a function to stop handling them.
+// A second stop signal is not handled, and so it terminates the process
+// immediately, in case the user does not want to wait for the workers.
+func handleSignals(p *Progress) (<-chan struct{}, func()) {
+	stopC := make(chan os.Signal, 1)
+	signal.Notify(stopC, stopSignals...)
+	statusC := make(chan os.Signal, 1)
+	signal.Notify(statusC, statusSignals...)
+	reloadC := make(chan os.Signal, 1)
+	if options.rateFile != "" {
+		signal.Notify(reloadC, syscall.SIGHUP)
+	}
+
+	stopped := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-stopC:
+				signal.Reset(stopSignals...)
+				p.PrintInterrupted(sig)
+				close(stopped)
+			case <-statusC:
+				p.PrintStatus()
+			case <-reloadC:
+				reloadRates(p)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return stopped, func() {
+		signal.Stop(stopC)
+		signal.Stop(statusC)
+		signal.Stop(reloadC)
+		close(done)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Signals that make us print the current status (see Progress.PrintStatus).
+// SIGINFO is what Ctrl-T sends on BSD systems.
+var statusSignals = []os.Signal{syscall.SIGUSR1, syscall.SIGINFO}
@@ -0,0 +1,11 @@
+//go:build !(darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Signals that make us print the current status (see Progress.PrintStatus).
+var statusSignals = []os.Signal{syscall.SIGUSR1}
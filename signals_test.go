@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestInterrupted(t *testing.T) {
+	dir := t.TempDir()
+	for i := range 10 {
+		path := filepath.Join(dir, fmt.Sprintf("file%d", i))
+		if err := os.WriteFile(path, nil, 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	oldParallel := options.parallel
+	options.parallel = 1
+	defer func() { options.parallel = oldParallel }()
+
+	// Send ourselves a SIGINT while processing the first file. The walk
+	// should stop after it, without processing any more files.
+	processed := 0
+	fn := func(fd *os.File, info fs.FileInfo, p *Progress) error {
+		processed++
+		if processed == 1 {
+			syscall.Kill(os.Getpid(), syscall.SIGINT)
+
+			// Give the signal handler time to run, since signals are
+			// delivered asynchronously.
+			time.Sleep(200 * time.Millisecond)
+		}
+		p.PrintMatched(fd.Name(), ChecksumV1{})
+		return nil
+	}
+
+	err := walk([]string{dir}, fn)
+	status, ok := err.(*exitStatus)
+	if !ok || status.code != exitInterrupted {
+		t.Errorf("expected exit status %d, got %v", exitInterrupted, err)
+	}
+	if processed != 1 {
+		t.Errorf("expected 1 file processed, got %d", processed)
+	}
+}
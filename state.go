@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// First line of the state files, to identify them (and their version).
+const stateHeader = "summer state v1"
+
+// State kept between runs, in an external file (see -state): when each file
+// was last verified successfully. Files are identified by their absolute
+// path, so it doesn't matter how they were given.
+//
+// It is kept outside of the checksum records, because updating them on each
+// verification would turn verify into a writing operation, and change the
+// files' ctime.
+type stateDB struct {
+	// File to load and save the state from ("" = don't keep state).
+	file string
+
+	// Working directory, to make paths absolute.
+	cwd string
+
+	mu sync.Mutex
+
+	// Last successful verification of each file, in Unix seconds.
+	verified map[string]int64
+}
+
+// Return a new state, loaded from the given file if it exists.
+func newStateDB(file string) (*stateDB, error) {
+	s := &stateDB{file: file, verified: map[string]int64{}}
+	if file == "" {
+		return s, nil
+	}
+
+	var err error
+	s.cwd, err = os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := os.Open(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading state: %w", err)
+	}
+	defer fd.Close()
+
+	scanner := bufio.NewScanner(fd)
+	for n := 0; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if n == 0 {
+			if line != stateHeader {
+				return nil, fmt.Errorf("%q: unknown format", file)
+			}
+			continue
+		}
+
+		ts, path, _ := strings.Cut(line, " ")
+		t, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q:%d: %v", file, n+1, err)
+		}
+		path, err = strconv.Unquote(path)
+		if err != nil {
+			return nil, fmt.Errorf("%q:%d: %v", file, n+1, err)
+		}
+		s.verified[path] = t
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error loading state: %w", err)
+	}
+	return s, nil
+}
+
+// Record that the file at the given path was verified successfully.
+func (s *stateDB) Verified(path string) {
+	if s.file == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.verified[absPath(s.cwd, path)] = time.Now().Unix()
+}
+
+// Return when the file at the given path was last verified, or the zero time
+// if it never was.
+func (s *stateDB) LastVerified(path string) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.verified[absPath(s.cwd, path)]
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(t, 0)
+}
+
+// Save the state to its file. The file is replaced atomically, so it is
+// never left half-written. Nothing is written in dry-run mode.
+func (s *stateDB) Save() error {
+	if s.file == "" || *dryRun {
+		return nil
+	}
+
+	s.mu.Lock()
+	paths := make([]string, 0, len(s.verified))
+	for path := range s.verified {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	w := &strings.Builder{}
+	fmt.Fprintf(w, "%s\n", stateHeader)
+	for _, path := range paths {
+		fmt.Fprintf(w, "%d %q\n", s.verified[path], path)
+	}
+	s.mu.Unlock()
+
+	return writeFileAtomic(s.file, []byte(w.String()))
+}
+
+// Write the data to the file, replacing it atomically: it's written to a
+// temporary file first, which is synced and then renamed over it, so if we
+// crash (or the system does) the file has either the old or the new contents.
+func writeFileAtomic(file string, data []byte) error {
+	tmp := file + ".tmp"
+	fd, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = fd.Write(data)
+	if err == nil {
+		err = fd.Sync()
+	}
+	if cerr := fd.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// Return the absolute version of the path, given the working directory.
+func absPath(cwd, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(cwd, path)
+}
+
+// Implements the "stats" command: print how many files there are in the
+// roots, and how many were not verified recently. Files not verified in the
+// given number of days (if not 0) are listed.
+func stats(roots []string, staleDays uint64) error {
+	if options.state.file == "" {
+		return errors.New("stats needs a state file (see -state)")
+	}
+
+	now := time.Now()
+	stale := now.Add(-time.Duration(staleDays) * 24 * time.Hour)
+	files, never, nStale := 0, 0, 0
+	oldest := now
+
+	for _, root := range roots {
+		rootDev := getDeviceForPath(root)
+		err := filepath.WalkDir(root,
+			func(path string, d fs.DirEntry, err error) error {
+				ok, _, err := selectFile(path, d, err, rootDev)
+				if !ok || err != nil {
+					return err
+				}
+
+				files++
+				t := options.state.LastVerified(path)
+				if t.IsZero() {
+					never++
+				} else if t.Before(oldest) {
+					oldest = t
+				}
+
+				if staleDays > 0 && t.Before(stale) {
+					nStale++
+					if t.IsZero() {
+						Printf("%q: never verified", path)
+					} else {
+						Printf("%q: last verified %s (%d days ago)",
+							path, t.Format(time.DateOnly),
+							int(now.Sub(t).Hours()/24))
+					}
+				}
+				return nil
+			})
+		if err != nil {
+			return err
+		}
+	}
+
+	status := fmt.Sprintf("%d files, %d never verified", files, never)
+	if oldest.Before(now) {
+		status += fmt.Sprintf(", oldest verified %s (%d days ago)",
+			oldest.Format(time.DateOnly), int(now.Sub(oldest).Hours()/24))
+	}
+	if staleDays > 0 {
+		status += fmt.Sprintf(", %d not verified in %d days",
+			nStale, staleDays)
+	}
+	Printf("%s", status)
+	return nil
+}
@@ -3,23 +3,64 @@ package main
 import (
 	"flag"
 	"fmt"
+	"hash/fnv"
 	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 // Flags.
 var (
 	subsetPct = flag.Uint("subsetpct", 100,
 		"percentage of files to process (0 = none, 100 = all)")
+	subsetBytes = flag.Bool("subsetbytes", false,
+		"make -subsetpct a percentage of the bytes instead of the files, "+
+			"so the amount of I/O is predictable")
 	randSeed = flag.Uint64("subsetseed", 0,
 		"seed for the subset selection PRNG, useful for testing (0 = random)")
+	scrubPeriod = flag.String("scrub-period", "",
+		"split the files in one slot per day of this period (e.g. 30d), "+
+			"and only process today's slot, so all files are processed "+
+			"once per period")
+	scrubSlot = flag.Int("scrub-slot", -1,
+		"slot to process with -scrub-period, instead of today's "+
+			"(from 0 to days-1)")
+	shardFlag = flag.String("shard", "",
+		"only process the files in shard i of n (given as i/n, with i from "+
+			"0 to n-1), selected by their path relative to the root, so "+
+			"the shards are disjoint and cover all the files")
 )
 
 type Subset struct {
 	// Percentage of files to process (0 = none, 100 = all).
 	percent uint
 
-	// Random source for subset selection.
+	// Select by bytes instead of by files (see pickBytes).
+	byBytes bool
+
+	// Bytes we are owed by the selection so far, when selecting by bytes.
+	credit float64
+
+	// Random source for subset selection, and the mutex that protects it
+	// (and credit), as files can be selected from multiple walkers.
+	mu   sync.Mutex
 	rand *rand.Rand
+
+	// Number of scrub slots (one per day in the period), and the one to
+	// process. If there are no slots, all files are in the slot.
+	slots, slot uint64
+
+	// Working directory, to make paths absolute, so they're assigned the
+	// same slot regardless of how they were given.
+	cwd string
+
+	// Number of shards, and the one to process. If there are no shards, all
+	// files are in the shard.
+	shards, shard uint64
 }
 
 func NewSubset() (*Subset, error) {
@@ -37,13 +78,148 @@ func NewSubset() (*Subset, error) {
 		seed2 = *randSeed
 	}
 
-	return &Subset{
+	s := &Subset{
 		percent: *subsetPct,
+		byBytes: *subsetBytes,
 		rand:    rand.New(rand.NewPCG(seed1, seed2)),
-	}, nil
+	}
+
+	if *scrubPeriod != "" {
+		days, err := parseDays(*scrubPeriod)
+		if err != nil {
+			return nil, err
+		}
+		s.slots = days
+		s.slot = today() % days
+		if *scrubSlot >= 0 {
+			if uint64(*scrubSlot) >= days {
+				return nil, fmt.Errorf(
+					"scrub slot %d must be in the [0, %d] range",
+					*scrubSlot, days-1)
+			}
+			s.slot = uint64(*scrubSlot)
+		}
+
+		s.cwd, err = os.Getwd()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if *shardFlag != "" {
+		var err error
+		s.shard, s.shards, err = parseShard(*shardFlag)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Parse a shard, given as "i/n", and return i and n.
+func parseShard(s string) (uint64, uint64, error) {
+	is, ns, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid shard %q, expected i/n", s)
+	}
+	i, err1 := strconv.ParseUint(is, 10, 64)
+	n, err2 := strconv.ParseUint(ns, 10, 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0, fmt.Errorf("invalid shard %q, expected i/n", s)
+	}
+	if n == 0 || i >= n {
+		return 0, 0, fmt.Errorf("shard %q must have i in the [0, n-1] range", s)
+	}
+	return i, n, nil
+}
+
+// Parse a period, given either in days (e.g. "30d"), or as a Go duration
+// (e.g. "720h"), and return the number of days in it.
+func parseDays(s string) (uint64, error) {
+	var days uint64
+	if n, ok := strings.CutSuffix(s, "d"); ok {
+		d, err := strconv.ParseUint(n, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid period %q", s)
+		}
+		days = d
+	} else {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid period %q", s)
+		}
+		if d%(24*time.Hour) != 0 {
+			return 0, fmt.Errorf("period %q must be a whole number of days", s)
+		}
+		days = uint64(d / (24 * time.Hour))
+	}
+
+	if days == 0 {
+		return 0, fmt.Errorf("period %q must be at least one day", s)
+	}
+	return days, nil
+}
+
+// Number of days since the epoch, according to the local calendar (so the
+// slot changes at local midnight).
+func today() uint64 {
+	y, m, d := time.Now().Date()
+	return uint64(time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Unix() / 86400)
+}
+
+// Should we process the file at the given path, found under root, with the
+// given size? Must be called once per file.
+func (s *Subset) ShouldProcess(root, path string, size int64) bool {
+	if !s.Includes(root, path) {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.byBytes {
+		return s.pickBytes(size)
+	}
+	return s.pick()
 }
 
-func (s *Subset) ShouldProcess() bool {
+// Is the file at the given path, found under root, in the deterministic part
+// of the selection (the scrub slot and the shard)? Unlike the percentage, this
+// can be used by prescan.
+func (s *Subset) Includes(root, path string) bool {
+	return s.InSlot(path) && s.InShard(root, path)
+}
+
+// Is the file at the given path in the scrub slot to process?
+func (s *Subset) InSlot(path string) bool {
+	if s.slots == 0 {
+		return true
+	}
+	return hashPath("scrub", absPath(s.cwd, path))%s.slots == s.slot
+}
+
+// Is the file at the given path, found under root, in the shard to process?
+// The path relative to the root is used, so the result is the same on hosts
+// that mount the tree in different places.
+func (s *Subset) InShard(root, path string) bool {
+	if s.shards == 0 {
+		return true
+	}
+	return hashPath("shard", relPath(root, path))%s.shards == s.shard
+}
+
+// Return the path relative to the root it was found under. If the root is the
+// file itself, its name is used.
+func relPath(root, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return filepath.Base(path)
+	}
+	return rel
+}
+
+// Pick randomly according to the percentage. Must be called with s.mu held.
+func (s *Subset) pick() bool {
 	// Special-case 0% and 100% to avoid picking a random number
 	// unnecessarily.
 	if s.percent == 100 {
@@ -52,7 +228,47 @@ func (s *Subset) ShouldProcess() bool {
 		return false
 	}
 
-	// Note this is NOT thread-safe, but the caller is single threaded so it's
-	// fine for our use case.
 	return s.rand.UintN(100) < s.percent
 }
+
+// Pick randomly so that the bytes picked are the percentage of the bytes seen.
+//
+// Picking files with the same probability would give the right percentage on
+// average, but a few big files can make it vary a lot between runs. So
+// instead, each file adds its share of the percentage to a credit, and it is
+// picked with a probability given by how much of its size the credit covers.
+// Picking it takes its size from the credit. That way, the bytes picked are
+// always within a file size of the target. Must be called with s.mu held.
+func (s *Subset) pickBytes(size int64) bool {
+	if s.percent == 100 {
+		return true
+	} else if s.percent == 0 {
+		return false
+	}
+
+	s.credit += float64(size) * float64(s.percent) / 100
+	if s.credit <= 0 {
+		return false
+	}
+
+	// Empty files cost nothing, so just pick them by count.
+	if size == 0 {
+		return s.rand.UintN(100) < s.percent
+	}
+
+	if s.credit < s.rand.Float64()*float64(size) {
+		return false
+	}
+	s.credit -= float64(size)
+	return true
+}
+
+// Hash the path, for deterministic selections. The salt is used so that
+// different kinds of selections are independent of each other.
+func hashPath(salt, path string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(salt))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	return h.Sum64()
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"math/rand/v2"
 	"testing"
 )
 
@@ -26,7 +27,7 @@ func testSubset(t *testing.T, pct uint) {
 	count := uint64(1_000_000)
 	selected := uint64(0)
 	for range count {
-		if subset.ShouldProcess() {
+		if subset.ShouldProcess(".", "file", 0) {
 			selected++
 		}
 	}
@@ -41,6 +42,48 @@ func testSubset(t *testing.T, pct uint) {
 	}
 }
 
+// Select by bytes from files with very different sizes, and check that the
+// bytes picked are always close to the percentage, regardless of the seed.
+func TestSubsetBytes(t *testing.T) {
+	sizes := []int64{}
+	total, biggest := int64(0), int64(0)
+	for i := range 10_000 {
+		size := int64(i % 100)
+		if i%1000 == 0 {
+			size = 10_000_000
+		}
+		sizes = append(sizes, size)
+		total += size
+		biggest = max(biggest, size)
+	}
+
+	for _, pct := range []uint{1, 10, 50, 90} {
+		for seed := range uint64(20) {
+			s := &Subset{
+				percent: pct,
+				byBytes: true,
+				rand:    rand.New(rand.NewPCG(0, seed)),
+			}
+			picked, files := int64(0), 0
+			for _, size := range sizes {
+				if s.ShouldProcess(".", "file", size) {
+					picked += size
+					files++
+				}
+			}
+
+			target := total * int64(pct) / 100
+			if picked < target-biggest || picked > target+biggest {
+				t.Errorf("%d%%, seed %d: picked %d bytes, expected %d ± %d",
+					pct, seed, picked, target, biggest)
+			}
+			if files == 0 {
+				t.Errorf("%d%%, seed %d: picked no files", pct, seed)
+			}
+		}
+	}
+}
+
 // Benchmark the performance of the ShouldProcess() method, for 0%, 50%, and
 // 100%. 0% and 100% should be faster since they don't pick a random number,
 // and the 50% case should give us a sense of the overhead of the random
@@ -62,6 +105,124 @@ func benchmarkSubset(b *testing.B, pct uint) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		subset.ShouldProcess()
+		subset.ShouldProcess(".", "file", 0)
+	}
+}
+
+func TestScrubSlots(t *testing.T) {
+	const slots = 30
+	counts := make([]int, slots)
+	for i := range 30_000 {
+		path := fmt.Sprintf("/dir%d/file%d", i%7, i)
+		in := 0
+		for slot := range uint64(slots) {
+			s := &Subset{percent: 100, slots: slots, slot: slot}
+			if s.ShouldProcess("/", path, 0) {
+				counts[slot]++
+				in++
+			}
+		}
+		if in != 1 {
+			t.Fatalf("%q is in %d slots, expected 1", path, in)
+		}
+	}
+
+	// Each slot should have roughly the same number of files (within 10%
+	// of the expected 1000).
+	for slot, n := range counts {
+		if n < 900 || n > 1100 {
+			t.Errorf("slot %d has %d files, expected ~1000", slot, n)
+		}
+	}
+
+	// Relative paths are assigned the same slot as their absolute version.
+	s := &Subset{percent: 100, slots: slots, cwd: "/dir3"}
+	for slot := range uint64(slots) {
+		s.slot = slot
+		if s.InSlot("file10") != s.InSlot("/dir3/file10") {
+			t.Errorf("slot %d: relative and absolute paths differ", slot)
+		}
+	}
+}
+
+func TestShards(t *testing.T) {
+	const shards = 4
+	counts := make([]int, shards)
+	for i := range 4_000 {
+		path := fmt.Sprintf("/mnt/a/dir%d/file%d", i%7, i)
+		in := 0
+		for shard := range uint64(shards) {
+			s := &Subset{percent: 100, shards: shards, shard: shard}
+			if s.ShouldProcess("/mnt/a", path, 0) {
+				counts[shard]++
+				in++
+			}
+
+			// The same tree mounted elsewhere must be in the same shard.
+			other := "/srv/b" + path[len("/mnt/a"):]
+			if s.InShard("/mnt/a", path) != s.InShard("/srv/b", other) {
+				t.Errorf("%q and %q are in different shards", path, other)
+			}
+		}
+		if in != 1 {
+			t.Fatalf("%q is in %d shards, expected 1", path, in)
+		}
+	}
+
+	// Each shard should have roughly the same number of files (within 10%
+	// of the expected 1000).
+	for shard, n := range counts {
+		if n < 900 || n > 1100 {
+			t.Errorf("shard %d has %d files, expected ~1000", shard, n)
+		}
+	}
+}
+
+func TestParseShard(t *testing.T) {
+	cases := []struct {
+		s    string
+		i, n uint64
+	}{
+		{"0/1", 0, 1},
+		{"0/3", 0, 3},
+		{"2/3", 2, 3},
+	}
+	for _, c := range cases {
+		i, n, err := parseShard(c.s)
+		if err != nil || i != c.i || n != c.n {
+			t.Errorf("parseShard(%q) = %d, %d, %v, expected %d, %d",
+				c.s, i, n, err, c.i, c.n)
+		}
+	}
+
+	for _, s := range []string{"", "1", "/", "1/", "/2", "0/0", "3/3", "-1/3", "a/b"} {
+		if i, n, err := parseShard(s); err == nil {
+			t.Errorf("parseShard(%q) = %d, %d, expected error", s, i, n)
+		}
+	}
+}
+
+func TestParseDays(t *testing.T) {
+	cases := []struct {
+		s    string
+		days uint64
+	}{
+		{"1d", 1},
+		{"30d", 30},
+		{"24h", 1},
+		{"168h", 7},
+	}
+	for _, c := range cases {
+		days, err := parseDays(c.s)
+		if err != nil || days != c.days {
+			t.Errorf("parseDays(%q) = %d, %v, expected %d",
+				c.s, days, err, c.days)
+		}
+	}
+
+	for _, s := range []string{"", "0d", "d", "-1d", "1.5d", "25h", "1w"} {
+		if days, err := parseDays(s); err == nil {
+			t.Errorf("parseDays(%q) = %d, expected error", s, days)
+		}
 	}
 }
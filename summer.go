@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"hash/crc32"
@@ -9,7 +10,8 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
-	"runtime"
+	"syscall"
+	"time"
 
 	"golang.org/x/term"
 )
@@ -36,9 +38,39 @@ Usage:
       are left untouched, and checksums are not verified.
       Useful when generating checksums for a lot of files for the first time,
       as is faster to resume work if interrupted.
+  summer [flags] plan <paths>
+      Scan the given paths without reading the files, and print how much data
+      there is to process (per path and per device), and an estimate of how
+      long it would take (see -planrate).
+  summer [flags] stats <paths>
+      Print how many files there are in the given paths, and how long ago
+      they were verified (requires -state). With -stale, list the files not
+      verified in that long.
   summer [flags] version
       Print software version information.
 
+Exit codes:
+
+  0   Success.
+  1   The run was aborted due to an error, or invalid usage.
+
+  Otherwise, the run completed (or was interrupted), and the exit code is a
+  combination (bitwise OR) of the following:
+
+  2   Some files could not be processed due to errors (see -keep-going), or
+      could not be read due to I/O errors.
+  4   Corrupted files were detected.
+  8   Files without checksums were found (verify with -strict only).
+  16  Policy violations were detected (see -sealed).
+  32  The run was interrupted by SIGINT or SIGTERM, so not all files were
+      processed. The files in progress are completed before exiting.
+  64  Suspect files were detected (see -sealed): their contents changed, and
+      of their metadata only the ctime did. It could be corruption.
+
+Send SIGUSR1 (or SIGINFO, where available) to print the current status and
+the files in progress. With -rate-file, send SIGHUP to reload the rate limits
+from it.
+
 Flags:
 `
 
@@ -48,8 +80,54 @@ var (
 	forceTTY      = flag.Bool("forcetty", false, "force TTY output")
 	exclude       = &RepeatedStringFlag{}
 	excludeRe     = &RepeatedStringFlag{}
+	devParallel   = &RepeatedStringFlag{}
 	parallel      = flag.Int("parallel", 0,
-		"number of files to process in parallel (0 = number of CPUs)")
+		"number of files to process in parallel on each device "+
+			"(0 = automatic: 1 for rotational disks, the number of CPUs "+
+			"otherwise)")
+	sealed = flag.Bool("sealed", false,
+		"sealed mode: report modified files as policy violations, and "+
+			"the ones where only the ctime changed as suspect")
+	rereadN = flag.Int("reread", 0,
+		"number of times to re-read corrupted files, bypassing the cache, "+
+			"to check if the mismatch is consistent")
+	keepGoing = flag.Bool("keep-going", false,
+		"keep going after errors, and list them at the end")
+	strict = flag.Bool("strict", false,
+		"strict verify: treat files without checksums as failures, and "+
+			"list them (use -sealed to also treat modified files as failures)")
+	format = flag.String("format", formatText,
+		"output format: text, or jsonl (one JSON object per line)")
+	reportFile = flag.String("report", "",
+		"write a report of the run to this file (JSON if it ends in .json)")
+	ioMode = flag.String("iomode", ioCached,
+		"how to read files: "+
+			"cached (through the page cache), "+
+			"nocache (drop files from the page cache before and after), "+
+			"direct (bypass the page cache with direct I/O)")
+	prescanPaths = flag.Bool("prescan", false,
+		"scan the paths before processing them, to show the overall "+
+			"progress and an ETA")
+	checkpointFile = flag.String("checkpoint", "",
+		"save the progress to this file periodically, and if it exists, "+
+			"resume from it (skipping what was already done)")
+	maxDuration = flag.Duration("max-duration", 0,
+		"stop handing out new files after this long (0 = no limit); "+
+			"use with -checkpoint to continue from there on the next run")
+	maxBytes  = new(ByteSizeFlag)
+	stateFile = flag.String("state", "",
+		"keep track of when each file was last verified in this file")
+	oldestFirst = flag.Bool("oldest-first", false,
+		"process the files verified the longest ago first (requires -state); "+
+			"useful with -max-duration or -max-bytes")
+	staleAge = flag.String("stale", "",
+		"for the stats command, list the files not verified in this long "+
+			"(e.g. 30d)")
+	idle = flag.Bool("idle", false,
+		"run at idle I/O priority (where supported) and the lowest CPU "+
+			"priority, to reduce the impact on other processes")
+	planRate = flag.Uint("planrate", 100,
+		"expected throughput for the plan command's estimate, in MiB/s")
 )
 
 var options = struct {
@@ -68,11 +146,66 @@ var options = struct {
 	// Regexp patterns to exclude.
 	excludeRe []*regexp.Regexp
 
-	// How many files to process in parallel.
-	parallel int
+	// How many files to process in parallel on each device (0 = automatic,
+	// see deviceParallel), and the devices that have a different number
+	// (see -device-parallel).
+	parallel       int
+	deviceParallel map[deviceID]int
 
 	// Subset to decide which files to process.
 	subset *Subset
+
+	// Sealed mode: modified files are policy violations, and their
+	// checksums are not updated.
+	sealed bool
+
+	// How many times to re-read files that look corrupted.
+	reread int
+
+	// How to read the file contents (ioCached, ioNoCache, ioDirect).
+	ioMode string
+
+	// Keep going after errors processing files.
+	keepGoing bool
+
+	// Strict mode: files without checksums are failures (verify only).
+	strict bool
+
+	// Output format (formatText, formatJSONL).
+	format string
+
+	// File to write the end-of-run report to (if any).
+	reportFile string
+
+	// Scan the paths before processing them, to know the total amount of
+	// work.
+	prescan bool
+
+	// Expected throughput, in MiB/s, for the plan command.
+	planRate uint
+
+	// File to save the walk progress to, and resume from (if any).
+	checkpoint string
+
+	// Limits for the run: once reached, no new files are processed
+	// (0 = no limit).
+	maxDuration time.Duration
+	maxBytes    int64
+
+	// State kept between runs (see -state).
+	state *stateDB
+
+	// Process the files verified the longest ago first.
+	oldestFirst bool
+
+	// File to load the rate limits from, on SIGHUP (if any).
+	rateFile string
+
+	// Limits on how fast we read (see -max-rate and -max-files-rate).
+	throttle *throttle
+
+	// Running at idle priority.
+	idle bool
 }{}
 
 func Usage() {
@@ -87,6 +220,16 @@ func main() {
 		"exclude these paths (can be repeated)")
 	flag.Var(excludeRe, "excludere",
 		"exclude paths matching this regexp (can be repeated)")
+	flag.Var(devParallel, "device-parallel",
+		"number of files to process in parallel on the device of a path, "+
+			"given as path=N, instead of -parallel (can be repeated)")
+	flag.Var(maxBytes, "max-bytes",
+		"stop handing out new files after this many bytes, "+
+			"with optional K/M/G/T/P suffix (0 = no limit); "+
+			"use with -checkpoint to continue from there on the next run")
+	flag.Var(maxRate, "max-rate",
+		"maximum number of bytes to read per second, across all workers, "+
+			"with optional K/M/G/T/P suffix, like 50MB/s (0 = no limit)")
 
 	flag.Usage = Usage
 	flag.Parse()
@@ -103,16 +246,95 @@ func main() {
 		options.excludeRe = append(options.excludeRe, regexp.MustCompile(s))
 	}
 
+	options.sealed = *sealed
+	options.reread = *rereadN
+	options.keepGoing = *keepGoing
+	options.strict = *strict
+	options.prescan = *prescanPaths
+	options.planRate = *planRate
+	options.checkpoint = *checkpointFile
+	options.maxDuration = *maxDuration
+	options.maxBytes = int64(*maxBytes)
+
+	options.oldestFirst = *oldestFirst
+	if options.oldestFirst && *stateFile == "" {
+		Fatalf("-oldest-first requires -state")
+	}
+	if options.oldestFirst && options.checkpoint != "" {
+		Fatalf("-oldest-first can't be used with -checkpoint " +
+			"(the state already tracks the progress)")
+	}
+
+	staleDays := uint64(0)
+	if *staleAge != "" {
+		staleDays, err = parseDays(*staleAge)
+		if err != nil {
+			Fatalf("%v", err)
+		}
+	}
+
+	options.format = *format
+	options.reportFile = *reportFile
+	switch options.format {
+	case formatText, formatJSONL:
+	default:
+		Fatalf("unknown output format %q", options.format)
+	}
+
+	options.ioMode = *ioMode
+	switch options.ioMode {
+	case ioCached, ioNoCache, ioDirect:
+	default:
+		Fatalf("unknown I/O mode %q", options.ioMode)
+	}
+
 	options.parallel = *parallel
-	if options.parallel == 0 {
-		options.parallel = runtime.NumCPU()
+	if options.parallel < 0 {
+		Fatalf("invalid -parallel %d", options.parallel)
+	}
+
+	options.deviceParallel = map[deviceID]int{}
+	for _, s := range *devParallel {
+		path, n, err := parseDeviceParallel(s)
+		if err != nil {
+			Fatalf("%v", err)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			Fatalf("%v", err)
+		}
+		options.deviceParallel[getDevice(fi)] = n
 	}
 
+	bytesRate, filesRate := int64(*maxRate), *maxFilesRate
+	if filesRate < 0 {
+		Fatalf("invalid -max-files-rate %g", filesRate)
+	}
+	options.rateFile = *rateFile
+	if options.rateFile != "" {
+		// The file takes precedence over the flags, if it exists.
+		br, fr, err := loadRates(options.rateFile)
+		if err == nil {
+			bytesRate, filesRate = br, fr
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			Fatalf("error loading rate limits: %v", err)
+		}
+	}
+	options.throttle = newThrottle(bytesRate, filesRate)
+
 	options.subset, err = NewSubset()
 	if err != nil {
 		Fatalf("%v", err)
 	}
 
+	// Do this before we start any goroutines, so all the threads get it.
+	options.idle = *idle
+	if options.idle {
+		if err := setIdlePriority(); err != nil {
+			Fatalf("error setting idle priority: %v", err)
+		}
+	}
+
 	op := flag.Arg(0)
 	roots := []string{}
 	if flag.NArg() > 1 {
@@ -127,6 +349,11 @@ func main() {
 	options.db = XattrDB{}
 	defer options.db.Close()
 
+	options.state, err = newStateDB(*stateFile)
+	if err != nil {
+		Fatalf("%v", err)
+	}
+
 	switch op {
 	case "generate":
 		err = walk(roots, generate)
@@ -134,17 +361,56 @@ func main() {
 		err = walk(roots, verify)
 	case "update":
 		err = walk(roots, update)
+	case "plan":
+		plan(roots)
+	case "stats":
+		err = stats(roots, staleDays)
 	case "version":
 		PrintVersion()
 	default:
 		Fatalf("unknown command %q", op)
 	}
 
+	var status *exitStatus
+	if errors.As(err, &status) {
+		// In JSON mode, the messages are part of the summary.
+		if options.format != formatJSONL {
+			for _, msg := range status.msgs {
+				fmt.Println(msg)
+			}
+		}
+		os.Exit(status.code)
+	}
 	if err != nil {
 		Fatalf("%v", err)
 	}
 }
 
+// Exit codes. Other than exitAborted, they are bit flags that can be
+// combined. They are documented in the usage message.
+const (
+	// The run was aborted due to an error.
+	exitAborted = 1
+
+	// Some files could not be processed due to errors.
+	exitErrors = 2
+
+	// Corrupted files were detected.
+	exitCorrupted = 4
+
+	// Files without checksums were found (verify with -strict only).
+	exitMissing = 8
+
+	// Policy violations were detected.
+	exitViolations = 16
+
+	// The run was interrupted by a signal.
+	exitInterrupted = 32
+
+	// Suspect files were detected.
+	exitSuspect = 64
+)
+
 func isExcluded(path string) bool {
 	if options.exclude[path] {
 		return true
@@ -172,12 +438,201 @@ type ChecksumV1 struct {
 	// To avoid this, we always read the modification time prior to reading
 	// the file contents. That way, if the file changes while we are reading
 	// it, it should be detected later as modified instead of corrupted.
+	// We also check the metadata again after reading the contents, and skip
+	// the file if it changed, so we don't record such checksums in the first
+	// place.
 	//
 	// This relies on mtime having enough resolution to detect the change. On
 	// some filesystems that may not be the case, and a file modified very
 	// quickly may not be detected as modified. This is a limitation of the
 	// filesystem, and there is nothing we can do about it.
 	ModTimeUsec int64
+
+	// Size of the file when the checksum was computed, in bytes.
+	Size int64
+
+	// Upper bound of the file's change time (ctime) after the checksum was
+	// written. In Unix microseconds.
+	//
+	// Some tools (e.g. "rsync -t", "cp -p") restore the mtime after writing
+	// to the file, so the mtime alone can't tell those changes apart from
+	// corruption. The ctime can't be set from userspace, so we use it for
+	// that.
+	//
+	// Writing the checksum to the file's extended attributes updates its
+	// ctime, so we can't know the final value in advance. Instead, we store
+	// the time right before writing the checksum, and consider the file's
+	// metadata changed only if its ctime is later than that by more than
+	// ctimeSlack.
+	//
+	// It is 0 for checksums written by older versions, which did not record
+	// the size nor the ctime.
+	CTimeUsec int64
+}
+
+// Tolerance when comparing a file's ctime against ChecksumV1.CTimeUsec, to
+// account for the kernel using a coarse clock for timestamps.
+const ctimeSlack = time.Second
+
+// Result of comparing a saved checksum with the current one.
+type compareResult int
+
+const (
+	// The checksum matches.
+	cmpMatched compareResult = iota
+
+	// The file was modified (the mtime changed).
+	cmpModified
+
+	// The file contents changed, but the mtime was preserved. We can tell it
+	// was modified because the size or the ctime changed too.
+	cmpPreservedMtime
+
+	// The file contents changed, but the metadata did not.
+	cmpCorrupted
+)
+
+func compare(saved, current ChecksumV1) compareResult {
+	if saved.ModTimeUsec != current.ModTimeUsec {
+		return cmpModified
+	}
+
+	if saved.CRC32C == current.CRC32C {
+		return cmpMatched
+	}
+
+	// The contents changed but the mtime did not. Check the rest of the
+	// metadata, if we have it, to see if it was legitimately modified.
+	if saved.CTimeUsec != 0 && current.CTimeUsec != 0 {
+		if saved.Size != current.Size ||
+			current.CTimeUsec > saved.CTimeUsec+ctimeSlack.Microseconds() {
+			return cmpPreservedMtime
+		}
+	}
+
+	return cmpCorrupted
+}
+
+// Is the change suspect? That is, the contents changed, and of the metadata
+// only the ctime did. Many things change the ctime without touching the
+// contents (chmod, renames, xattrs), so in sealed mode, where files are not
+// expected to change, we report these separately, as they could be
+// corruption.
+func isSuspect(saved, current ChecksumV1) bool {
+	return compare(saved, current) == cmpPreservedMtime &&
+		saved.Size == current.Size
+}
+
+// Returned by checksum when the file changed while we were reading it.
+var errChangedDuringScan = errors.New(
+	"file changed while reading it, skipped")
+
+// Compute the checksum of the file contents, along with the metadata we keep
+// in ChecksumV1.
+func checksum(fd *os.File, info fs.FileInfo, p *Progress) (ChecksumV1, error) {
+	h := crc32.New(crc32c)
+	w := io.Writer(h)
+	if p != nil {
+		// Keep track of the bytes read, for the progress output.
+		w = io.MultiWriter(h, p.ReadCounter(fd.Name()))
+	}
+	err := readContents(w, fd)
+	if errors.Is(err, syscall.EIO) {
+		// Media errors: find out which parts of the file can't be read, so
+		// we can report them.
+		ue, ferr := findUnreadable(fd, info.Size())
+		if ferr != nil {
+			return ChecksumV1{}, ferr
+		}
+		return ChecksumV1{}, ue
+	}
+	if err != nil {
+		return ChecksumV1{}, err
+	}
+
+	// Check that the file did not change while we were reading it, because
+	// then the checksum could be wrong.
+	after, err := fd.Stat()
+	if err != nil {
+		return ChecksumV1{}, err
+	}
+	if !after.ModTime().Equal(info.ModTime()) ||
+		after.Size() != info.Size() ||
+		getCTimeUsec(after) != getCTimeUsec(info) {
+		return ChecksumV1{}, errChangedDuringScan
+	}
+
+	return ChecksumV1{
+		CRC32C:      h.Sum32(),
+		ModTimeUsec: info.ModTime().UnixMicro(),
+		Size:        info.Size(),
+		CTimeUsec:   getCTimeUsec(info),
+	}, nil
+}
+
+// Result of re-reading a file that looks corrupted.
+type rereadResult struct {
+	// How many times the file was re-read.
+	n int
+
+	// How many of the re-reads gave a different checksum than the first
+	// read.
+	differ int
+}
+
+func (rr rereadResult) String() string {
+	if rr.n == 0 {
+		return ""
+	}
+	if rr.differ == 0 {
+		return fmt.Sprintf("consistent in %d re-reads", rr.n)
+	}
+	return fmt.Sprintf(
+		"intermittent, %d of %d re-reads differ, suspect RAM or transport",
+		rr.differ, rr.n)
+}
+
+// Re-read a file that looks corrupted, to tell apart consistent mismatches
+// (most likely on-disk corruption) from intermittent ones (which point to
+// problems elsewhere, like RAM or the transport).
+func reread(fd *os.File, info fs.FileInfo, got ChecksumV1, p *Progress) (rereadResult, error) {
+	rr := rereadResult{}
+	for rr.n < options.reread {
+		// Drop the file from the page cache, so we read it from the storage
+		// again. This is best-effort, it may not be supported.
+		dropCache(fd)
+
+		_, err := fd.Seek(0, io.SeekStart)
+		if err != nil {
+			return rr, err
+		}
+
+		cs, err := checksum(fd, info, p)
+		if err != nil {
+			return rr, err
+		}
+
+		rr.n++
+		if cs.CRC32C != got.CRC32C {
+			rr.differ++
+		}
+	}
+	return rr, nil
+}
+
+func writeChecksum(fd *os.File, cs ChecksumV1) error {
+	// Writing the checksum changes the ctime, so we store the current time
+	// as its upper bound. See ChecksumV1.CTimeUsec for more details.
+	cs.CTimeUsec = time.Now().UnixMicro()
+	err := options.db.Write(fd, cs)
+	if err != nil {
+		return err
+	}
+
+	// The checksum was just computed from the contents, so this counts as
+	// verifying the file.
+	options.state.Verified(fd.Name())
+	return nil
 }
 
 func generate(fd *os.File, info fs.FileInfo, p *Progress) error {
@@ -190,18 +645,12 @@ func generate(fd *os.File, info fs.FileInfo, p *Progress) error {
 		return nil
 	}
 
-	h := crc32.New(crc32c)
-	_, err = io.Copy(h, fd)
+	csum, err := checksum(fd, info, p)
 	if err != nil {
 		return err
 	}
 
-	csum := ChecksumV1{
-		CRC32C:      h.Sum32(),
-		ModTimeUsec: info.ModTime().UnixMicro(),
-	}
-
-	err = options.db.Write(fd, csum)
+	err = writeChecksum(fd, csum)
 	if err != nil {
 		return err
 	}
@@ -225,23 +674,32 @@ func verify(fd *os.File, info fs.FileInfo, p *Progress) error {
 		return err
 	}
 
-	h := crc32.New(crc32c)
-	_, err = io.Copy(h, fd)
+	csumComputed, err := checksum(fd, info, p)
 	if err != nil {
 		return err
 	}
 
-	csumComputed := ChecksumV1{
-		CRC32C:      h.Sum32(),
-		ModTimeUsec: info.ModTime().UnixMicro(),
-	}
-
-	if csumFromFile.ModTimeUsec != csumComputed.ModTimeUsec {
+	res := compare(csumFromFile, csumComputed)
+	switch {
+	case res == cmpCorrupted:
+		rr, err := reread(fd, info, csumComputed, p)
+		if err != nil {
+			return err
+		}
+		p.PrintCorrupted(fd.Name(), csumFromFile, csumComputed, rr)
+	case options.sealed && isSuspect(csumFromFile, csumComputed):
+		p.PrintSuspect(fd.Name(), csumFromFile, csumComputed)
+	case res != cmpMatched && options.sealed:
+		p.PrintViolation(fd.Name(), csumFromFile, csumComputed)
+	case res == cmpModified:
 		p.PrintModified(fd.Name(), csumFromFile, csumComputed)
-	} else if csumFromFile.CRC32C != csumComputed.CRC32C {
-		p.PrintCorrupted(fd.Name(), csumFromFile, csumComputed)
-	} else {
+	case res == cmpPreservedMtime:
+		p.PrintPreservedMtime(fd.Name(), csumFromFile, csumComputed)
+	default:
+		// Only a match verifies the stored checksum; the others leave it
+		// stale until the next update.
 		p.PrintMatched(fd.Name(), csumComputed)
+		options.state.Verified(fd.Name())
 	}
 
 	return nil
@@ -249,17 +707,11 @@ func verify(fd *os.File, info fs.FileInfo, p *Progress) error {
 
 func update(fd *os.File, info fs.FileInfo, p *Progress) error {
 	// Compute checksum from the current state.
-	h := crc32.New(crc32c)
-	_, err := io.Copy(h, fd)
+	csumComputed, err := checksum(fd, info, p)
 	if err != nil {
 		return err
 	}
 
-	csumComputed := ChecksumV1{
-		CRC32C:      h.Sum32(),
-		ModTimeUsec: info.ModTime().UnixMicro(),
-	}
-
 	// Read the saved checksum (if any).
 	hasAttr, err := options.db.Has(fd)
 	if err != nil {
@@ -268,7 +720,7 @@ func update(fd *os.File, info fs.FileInfo, p *Progress) error {
 	if !hasAttr {
 		// Attribute is missing. Expected for newly created files.
 		p.PrintMissing(fd.Name(), &csumComputed)
-		return options.db.Write(fd, csumComputed)
+		return writeChecksum(fd, csumComputed)
 	}
 
 	csumFromFile, err := options.db.Read(fd)
@@ -276,15 +728,34 @@ func update(fd *os.File, info fs.FileInfo, p *Progress) error {
 		return err
 	}
 
-	if csumFromFile.ModTimeUsec != csumComputed.ModTimeUsec {
+	res := compare(csumFromFile, csumComputed)
+	switch {
+	case res == cmpCorrupted:
+		rr, err := reread(fd, info, csumComputed, p)
+		if err != nil {
+			return err
+		}
+		p.PrintCorrupted(fd.Name(), csumFromFile, csumComputed, rr)
+		return nil
+	case res == cmpMatched:
+		p.PrintMatched(fd.Name(), csumComputed)
+		options.state.Verified(fd.Name())
+		return nil
+	case options.sealed && isSuspect(csumFromFile, csumComputed):
+		// It could be corruption, keep the old checksum.
+		p.PrintSuspect(fd.Name(), csumFromFile, csumComputed)
+		return nil
+	case options.sealed:
+		// Sealed files must not change, keep the old checksum.
+		p.PrintViolation(fd.Name(), csumFromFile, csumComputed)
+		return nil
+	case res == cmpPreservedMtime:
+		// File modified by a tool that preserves the mtime.
+		p.PrintPreservedMtime(fd.Name(), csumFromFile, csumComputed)
+	default:
 		// File modified. Expected for updated files.
 		p.PrintModified(fd.Name(), csumFromFile, csumComputed)
-		return options.db.Write(fd, csumComputed)
-	} else if csumFromFile.CRC32C != csumComputed.CRC32C {
-		p.PrintCorrupted(fd.Name(), csumFromFile, csumComputed)
-	} else {
-		p.PrintMatched(fd.Name(), csumComputed)
 	}
 
-	return nil
+	return writeChecksum(fd, csumComputed)
 }
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"io"
+	"os"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	base := ChecksumV1{
+		CRC32C:      0x1234,
+		ModTimeUsec: 1_000_000,
+		Size:        10,
+		CTimeUsec:   5_000_000,
+	}
+
+	// Returns a copy of base, modified by f.
+	with := func(f func(c *ChecksumV1)) ChecksumV1 {
+		c := base
+		f(&c)
+		return c
+	}
+
+	// Checksum written by an older version, without size nor ctime.
+	legacy := with(func(c *ChecksumV1) { c.Size = 0; c.CTimeUsec = 0 })
+
+	cases := []struct {
+		saved, current ChecksumV1
+		expected       compareResult
+	}{
+		{base, base, cmpMatched},
+		{legacy, base, cmpMatched},
+
+		// Only metadata changes are not a problem if the contents match.
+		{base, with(func(c *ChecksumV1) { c.CTimeUsec = 9_000_000 }),
+			cmpMatched},
+
+		{base, with(func(c *ChecksumV1) { c.ModTimeUsec = 2_000_000 }),
+			cmpModified},
+		{base, with(func(c *ChecksumV1) {
+			c.ModTimeUsec = 2_000_000
+			c.CRC32C = 0x5678
+		}), cmpModified},
+
+		// Contents changed, mtime preserved, but size changed.
+		{base, with(func(c *ChecksumV1) { c.CRC32C = 0x5678; c.Size = 11 }),
+			cmpPreservedMtime},
+		{base, with(func(c *ChecksumV1) {
+			c.CRC32C = 0x5678
+			c.Size = 11
+			c.CTimeUsec = 9_000_000
+		}), cmpPreservedMtime},
+
+		// Contents changed, mtime preserved, and only the ctime moved
+		// (e.g. cp -p over a file of the same size).
+		{base, with(func(c *ChecksumV1) {
+			c.CRC32C = 0x5678
+			c.CTimeUsec = 9_000_000
+		}), cmpPreservedMtime},
+
+		// Contents changed, and metadata did not (or it's within the slack).
+		{base, with(func(c *ChecksumV1) { c.CRC32C = 0x5678 }),
+			cmpCorrupted},
+		{base, with(func(c *ChecksumV1) {
+			c.CRC32C = 0x5678
+			c.CTimeUsec = 5_500_000
+		}), cmpCorrupted},
+
+		// Without size nor ctime, we can't tell if it was modified.
+		{legacy, with(func(c *ChecksumV1) { c.CRC32C = 0x5678; c.Size = 11 }),
+			cmpCorrupted},
+	}
+
+	for i, c := range cases {
+		got := compare(c.saved, c.current)
+		if got != c.expected {
+			t.Errorf("%d: compare(%+v, %+v) = %v, expected %v",
+				i, c.saved, c.current, got, c.expected)
+		}
+	}
+}
+
+func TestIsSuspect(t *testing.T) {
+	saved := ChecksumV1{
+		CRC32C:      0x1234,
+		ModTimeUsec: 1_000_000,
+		Size:        10,
+		CTimeUsec:   5_000_000,
+	}
+
+	// Contents and ctime changed, but not the size.
+	current := saved
+	current.CRC32C = 0x5678
+	current.CTimeUsec = 9_000_000
+	if !isSuspect(saved, current) {
+		t.Errorf("expected %+v -> %+v to be suspect", saved, current)
+	}
+
+	// A size change tells us it was modified.
+	current.Size = 11
+	if isSuspect(saved, current) {
+		t.Errorf("expected %+v -> %+v not to be suspect", saved, current)
+	}
+
+	// Same for an mtime change.
+	current.Size = 10
+	current.ModTimeUsec = 2_000_000
+	if isSuspect(saved, current) {
+		t.Errorf("expected %+v -> %+v not to be suspect", saved, current)
+	}
+}
+
+func TestChecksumChangedDuringScan(t *testing.T) {
+	path := t.TempDir() + "/file"
+	if err := os.WriteFile(path, []byte("marola\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+
+	fd, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	info, err := fd.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	csum, err := checksum(fd, info, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if csum.CRC32C != 0x239059f6 || csum.Size != 7 {
+		t.Errorf("unexpected checksum: %+v", csum)
+	}
+
+	// Simulate the file changing while we read it, by modifying it after
+	// obtaining the information.
+	if err := os.WriteFile(path, []byte("sospechoso\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	fd.Seek(0, io.SeekStart)
+
+	_, err = checksum(fd, info, nil)
+	if err != errChangedDuringScan {
+		t.Errorf("expected errChangedDuringScan, got %v", err)
+	}
+}
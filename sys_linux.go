@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func getCTimeUsec(info fs.FileInfo) int64 {
+	st := info.Sys().(*syscall.Stat_t)
+	return time.Unix(st.Ctim.Unix()).UnixMicro()
+}
+
+// Drop the file's contents from the page cache.
+func dropCache(fd *os.File) error {
+	return unix.Fadvise(int(fd.Fd()), 0, 0, unix.FADV_DONTNEED)
+}
+
+// Enable direct I/O on the file descriptor.
+func setDirectIO(fd *os.File) error {
+	flags, err := unix.FcntlInt(fd.Fd(), unix.F_GETFL, 0)
+	if err != nil {
+		return err
+	}
+	_, err = unix.FcntlInt(fd.Fd(), unix.F_SETFL, flags|unix.O_DIRECT)
+	return err
+}
+
+// Values for ioprio_set(2), which are not in the unix package.
+const (
+	ioprioWhoProcess = 1
+	ioprioClassIdle  = 3
+	ioprioClassShift = 13
+)
+
+// Put the process in the idle I/O scheduling class, and at the lowest CPU
+// priority. On Linux both are per thread, so we set them on all the existing
+// threads; the ones created later inherit them from their parent.
+func setIdlePriority() error {
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return err
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+
+		// The thread may have exited in the meantime, that's fine.
+		_, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess,
+			uintptr(tid), ioprioClassIdle<<ioprioClassShift)
+		if errno != 0 && errno != unix.ESRCH {
+			return fmt.Errorf("ioprio_set: %w", errno)
+		}
+
+		err = unix.Setpriority(unix.PRIO_PROCESS, tid, 19)
+		if err != nil && err != unix.ESRCH {
+			return fmt.Errorf("setpriority: %w", err)
+		}
+	}
+	return nil
+}
+
+// Find out if the device is rotational (a spinning disk), from sysfs. Returns
+// an error if we can't tell: if it's not a block device (like network and
+// virtual filesystems), or it's a loop device, as what it says is not about
+// the storage behind it.
+func isRotational(dev deviceID) (bool, error) {
+	// This is a link to the device's directory, which is named after it.
+	dir, err := filepath.EvalSymlinks(fmt.Sprintf("/sys/dev/block/%v", dev))
+	if err != nil {
+		return false, errors.New("not a block device")
+	}
+
+	// Partitions don't have their own queue, they use their disk's.
+	if _, err := os.Stat(dir + "/partition"); err == nil {
+		dir = filepath.Dir(dir)
+	}
+
+	if strings.HasPrefix(filepath.Base(dir), "loop") {
+		return false, errors.New("loop device")
+	}
+
+	b, err := os.ReadFile(dir + "/queue/rotational")
+	if err != nil {
+		return false, errors.New("unknown device type")
+	}
+	return strings.TrimSpace(string(b)) == "1", nil
+}
@@ -0,0 +1,39 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// On other platforms we don't know the ctime, and checks that depend on it
+// are skipped.
+func getCTimeUsec(info fs.FileInfo) int64 {
+	return 0
+}
+
+// Dropping the file's contents from the page cache is not supported on
+// other platforms.
+func dropCache(fd *os.File) error {
+	return nil
+}
+
+// Direct I/O is not supported on other platforms.
+func setDirectIO(fd *os.File) error {
+	return errors.ErrUnsupported
+}
+
+// On other platforms we only lower the CPU priority, as there is no portable
+// way to set the I/O priority.
+func setIdlePriority() error {
+	return unix.Setpriority(unix.PRIO_PROCESS, 0, 19)
+}
+
+// We don't know how to tell if a device is rotational on other platforms.
+func isRotational(dev deviceID) (bool, error) {
+	return false, errors.New("unknown device type")
+}
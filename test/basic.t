@@ -27,7 +27,9 @@ Check handling of new and updated files.
   $ summer verify .
   0s: 3 matched, 0 modified, 0 new, 0 corrupted
 
-Corrupt a file by changing its contents without changing the mtime.
+Modify a file's contents while preserving its mtime, like "rsync -t" or
+"cp -p" do. It should be detected as modified, because the size and ctime
+changed.
 
   $ OLD_MTIME=`stat -c "%y" hola`
   $ echo sospechoso >> hola
@@ -35,11 +37,63 @@ Corrupt a file by changing its contents without changing the mtime.
   0s: 2 matched, 1 modified, 0 new, 0 corrupted
   $ touch --date="$OLD_MTIME" hola
 
+  $ summer verify .
+  "hola": file modified with preserved mtime (not corrupted) (checksum: 239059f6 -> 916db13f, size: 7 -> 18)
+  0s: 2 matched, 0 modified, 0 new, 0 corrupted, 1 modified with preserved mtime
+  $ summer update .
+  "hola": file modified with preserved mtime (not corrupted) (checksum: 239059f6 -> 916db13f, size: 7 -> 18)
+  0s: 2 matched, 0 modified, 0 new, 0 corrupted, 1 modified with preserved mtime
+  $ summer verify .
+  0s: 3 matched, 0 modified, 0 new, 0 corrupted
+
+Corrupt a file by changing its contents without changing its metadata.
+We can't do that from userspace because the ctime always changes, so instead
+we write a checksum in the old format (which has no size nor ctime) for the
+previous contents.
+
+  $ touch --date=@1700000000 hola
+  $ xattr -w -x user.summer-v1 f659902300401e18240a0600 hola
+
   $ summer verify .
   "hola": FILE CORRUPTED - expected:239059f6, got:916db13f
   0s: 2 matched, 0 modified, 0 new, 1 corrupted
+  corrupted files:
+    "hola": expected:239059f6, got:916db13f
   detected 1 corrupted files
-  [1]
+  [4]
+
+Re-reading the file confirms the corruption is consistent.
+
+  $ summer -reread=3 verify .
+  "hola": FILE CORRUPTED - expected:239059f6, got:916db13f (consistent in 3 re-reads)
+  0s: 2 matched, 0 modified, 0 new, 1 corrupted
+  corrupted files:
+    "hola": expected:239059f6, got:916db13f (consistent in 3 re-reads)
+  detected 1 corrupted files
+  [4]
+
+The report can also be written to a file, in text or JSON.
+
+  $ summer -q -report=../report.txt verify .
+  detected 1 corrupted files
+  [4]
+  $ cat ../report.txt
+  summer report, started .* (re)
+  0s: 2 matched, 0 modified, 0 new, 1 corrupted
+  corrupted files:
+    "hola": expected:239059f6, got:916db13f
+  detected 1 corrupted files
+
+  $ summer -q -report=../report.json verify .
+  detected 1 corrupted files
+  [4]
+  $ grep -A 5 '"corrupted": \[' ../report.json
+    "corrupted": [
+      {
+        "path": "hola",
+        "detail": "expected:239059f6, got:916db13f"
+      }
+    ],
 
 Check that "update" also detects the corruption, and doesn't just step over
 it.
@@ -47,8 +101,10 @@ it.
   $ summer update .
   "hola": FILE CORRUPTED - expected:239059f6, got:916db13f
   0s: 2 matched, 0 modified, 0 new, 1 corrupted
+  corrupted files:
+    "hola": expected:239059f6, got:916db13f
   detected 1 corrupted files
-  [1]
+  [4]
 
 Editing the file makes us ignore the previous checksum.
 
@@ -77,6 +133,18 @@ output.
   $ summer -q verify .
   $ rm denuevo
 
+Check the different I/O modes.
+
+  $ summer -iomode=nocache verify .
+  0s: 3 matched, 0 modified, 0 new, 0 corrupted
+  $ summer -iomode=direct verify .
+  0s: 3 matched, 0 modified, 0 new, 0 corrupted
+  $ summer -iomode=cached verify .
+  0s: 3 matched, 0 modified, 0 new, 0 corrupted
+  $ summer -iomode=invalid verify .
+  unknown I/O mode "invalid"
+  [1]
+
 Check that symlinks are ignored.
 
   $ ln -s hola thisisasymlink
@@ -0,0 +1,138 @@
+Tests for resuming runs with checkpoint files.
+
+  $ alias summer="$TESTDIR/../summer"
+  $ mkdir -p A/sub B C
+  $ touch A/a1 A/a2 A/sub/s1 B/b1 C/c1
+  $ summer -q generate A B C
+
+Without a checkpoint file, nothing is skipped, and no checkpoint is left
+behind when the run completes.
+
+  $ summer -checkpoint=cp verify A B C
+  0s: 5 matched, 0 modified, 0 new, 0 corrupted
+  $ test -e cp
+  [1]
+
+Resume from a checkpoint in the middle of a root. What comes before the last
+file done is skipped, including whole directories.
+
+  $ cat > cp <<EOT
+  > summer checkpoint v1
+  > root "A"
+  > root "B"
+  > root "C"
+  > done 0 "A/sub/s1"
+  > EOT
+  $ summer -checkpoint=cp --parallel=1 -v verify A B C
+  resuming from checkpoint "cp", skipping up to "A/sub/s1"
+  "B/b1": match \(checksum:0, mtime:\d+\) (re)
+  "C/c1": match \(checksum:0, mtime:\d+\) (re)
+  0s: 2 matched, 0 modified, 0 new, 0 corrupted
+  $ test -e cp
+  [1]
+
+  $ cat > cp <<EOT
+  > summer checkpoint v1
+  > root "A"
+  > root "B"
+  > root "C"
+  > done 0 "A/a1"
+  > EOT
+  $ summer -checkpoint=cp --parallel=1 -v verify A B C
+  resuming from checkpoint "cp", skipping up to "A/a1"
+  "A/a2": match \(checksum:0, mtime:\d+\) (re)
+  "A/sub/s1": match \(checksum:0, mtime:\d+\) (re)
+  "B/b1": match \(checksum:0, mtime:\d+\) (re)
+  "C/c1": match \(checksum:0, mtime:\d+\) (re)
+  0s: 4 matched, 0 modified, 0 new, 0 corrupted
+
+Whole roots are skipped too. Roots on different devices are walked at the
+same time, so the progress of each one is kept separately.
+
+  $ cat > cp <<EOT
+  > summer checkpoint v1
+  > root "A"
+  > root "B"
+  > root "C"
+  > finished 0
+  > done 1 "B/b1"
+  > EOT
+  $ summer -checkpoint=cp --parallel=1 -v update A B C
+  resuming from checkpoint "cp", skipping "A"
+  resuming from checkpoint "cp", skipping up to "B/b1"
+  "C/c1": match \(checksum:0, mtime:\d+\) (re)
+  0s: 1 matched, 0 modified, 0 new, 0 corrupted
+
+Checkpoints for different paths are ignored.
+
+  $ cat > cp <<EOT
+  > summer checkpoint v1
+  > root "A"
+  > done 0 "A/a2"
+  > EOT
+  $ summer -checkpoint=cp verify A B C
+  checkpoint "cp" is for different paths, ignoring it
+  0s: 5 matched, 0 modified, 0 new, 0 corrupted
+
+Invalid checkpoint files are an error.
+
+  $ echo lalala > cp
+  $ summer -checkpoint=cp verify A B C
+  error loading checkpoint: "cp": unknown format
+  [1]
+
+The checkpoint is kept when the run is aborted, so it can be resumed.
+
+  $ rm cp
+  $ chmod 000 B/b1
+  $ summer -checkpoint=cp --parallel=1 verify A B C
+  0s: 3 matched, 0 modified, 0 new, 0 corrupted
+  open B/b1: permission denied
+  [1]
+  $ cat cp
+  summer checkpoint v1
+  root "A"
+  root "B"
+  root "C"
+  finished 0
+
+A file whose error aborts the run is not done, so the run resumes from before
+it, and reports it again.
+
+  $ rm cp
+  $ chmod 644 B/b1
+  $ xattr -w user.summer-v1 "xxxx" A/a2
+  $ summer -checkpoint=cp --parallel=1 verify A B C
+  0s: \d matched, 0 modified, 0 new, 0 corrupted (re)
+  error in "A/a2": unexpected EOF
+  [1]
+  $ cat cp
+  summer checkpoint v1
+  root "A"
+  root "B"
+  root "C"
+  done 0 "A/a1"
+  $ summer -checkpoint=cp --parallel=1 verify A B C
+  resuming from checkpoint "cp", skipping up to "A/a1"
+  0s: \d matched, 0 modified, 0 new, 0 corrupted (re)
+  error in "A/a2": unexpected EOF
+  [1]
+
+A root that no longer exists is reported as usual when resuming, even if the
+checkpoint is past it.
+
+  $ mkdir -p X Y
+  $ touch X/x1 Y/y1
+  $ summer -q generate X Y
+  $ cat > cpX <<EOT
+  > summer checkpoint v1
+  > root "X"
+  > root "Y"
+  > done 0 "X/x1"
+  > EOT
+  $ rm -r X
+  $ summer -checkpoint=cpX --parallel=1 verify X Y
+  resuming from checkpoint "cpX", skipping up to "X/x1"
+  0s: 0 matched, 0 modified, 0 new, 0 corrupted
+  lstat X: no such file or directory
+  [1]
@@ -0,0 +1,38 @@
+Tests for the per-device parallelism.
+
+  $ alias summer="$TESTDIR/../summer"
+  $ mkdir A B
+  $ touch A/a1 A/a2 B/b1
+  $ summer -q generate A B
+
+Roots on the same device are walked one after the other, with the device's
+number of workers.
+
+  $ summer -parallel=4 -device-parallel=A=1 -v verify A B
+  "A/a1": match \(checksum:0, mtime:\d+\) (re)
+  "A/a2": match \(checksum:0, mtime:\d+\) (re)
+  "B/b1": match \(checksum:0, mtime:\d+\) (re)
+  0s: 3 matched, 0 modified, 0 new, 0 corrupted
+
+Without -parallel, the number of files to process in parallel is chosen
+according to the kind of device, and shown in verbose mode.
+
+  $ summer -v verify B
+  device \d+:\d+: \d+ in parallel \((rotational|non-rotational|not a block device, using the number of CPUs|loop device, using the number of CPUs)\) (re)
+  "B/b1": match \(checksum:0, mtime:\d+\) (re)
+  0s: 1 matched, 0 modified, 0 new, 0 corrupted
+
+Invalid values.
+
+  $ summer -parallel=-1 verify A
+  invalid -parallel -1
+  [1]
+  $ summer -device-parallel=A verify A
+  invalid device parallelism "A", expected path=N
+  [1]
+  $ summer -device-parallel=A=0 verify A
+  invalid device parallelism "A=0", expected path=N with N > 0
+  [1]
+  $ summer -device-parallel=nope=2 verify A
+  stat nope: no such file or directory
+  [1]
@@ -27,20 +27,98 @@ No arguments.
         are left untouched, and checksums are not verified.
         Useful when generating checksums for a lot of files for the first time,
         as is faster to resume work if interrupted.
+    summer [flags] plan <paths>
+        Scan the given paths without reading the files, and print how much data
+        there is to process (per path and per device), and an estimate of how
+        long it would take (see -planrate).
+    summer [flags] stats <paths>
+        Print how many files there are in the given paths, and how long ago
+        they were verified (requires -state). With -stale, list the files not
+        verified in that long.
     summer [flags] version
         Print software version information.
   
+  Exit codes:
+  
+    0   Success.
+    1   The run was aborted due to an error, or invalid usage.
+  
+    Otherwise, the run completed (or was interrupted), and the exit code is a
+    combination (bitwise OR) of the following:
+  
+    2   Some files could not be processed due to errors (see -keep-going), or
+        could not be read due to I/O errors.
+    4   Corrupted files were detected.
+    8   Files without checksums were found (verify with -strict only).
+    16  Policy violations were detected (see -sealed).
+    32  The run was interrupted by SIGINT or SIGTERM, so not all files were
+        processed. The files in progress are completed before exiting.
+    64  Suspect files were detected (see -sealed): their contents changed, and
+        of their metadata only the ctime did. It could be corruption.
+  
+  Send SIGUSR1 (or SIGINFO, where available) to print the current status and
+  the files in progress. With -rate-file, send SIGHUP to reload the rate limits
+  from it.
+  
   Flags:
+    -checkpoint string
+      \tsave the progress to this file periodically, and if it exists, resume from it (skipping what was already done) (esc)
+    -device-parallel value
+      \tnumber of files to process in parallel on the device of a path, given as path=N, instead of -parallel (can be repeated) (esc)
     -exclude value
       \texclude these paths (can be repeated) (esc)
     -excludere value
       \texclude paths matching this regexp (can be repeated) (esc)
     -forcetty
       \tforce TTY output (esc)
+    -format string
+      \toutput format: text, or jsonl (one JSON object per line) (default "text") (esc)
+    -idle
+      \trun at idle I/O priority (where supported) and the lowest CPU priority, to reduce the impact on other processes (esc)
+    -iomode string
+      \thow to read files: cached (through the page cache), nocache (drop files from the page cache before and after), direct (bypass the page cache with direct I/O) (default "cached") (esc)
+    -keep-going
+      \tkeep going after errors, and list them at the end (esc)
+    -max-bytes value
+      \tstop handing out new files after this many bytes, with optional K/M/G/T/P suffix (0 = no limit); use with -checkpoint to continue from there on the next run (esc)
+    -max-duration duration
+      \tstop handing out new files after this long (0 = no limit); use with -checkpoint to continue from there on the next run (esc)
+    -max-files-rate float
+      \tmaximum number of files to start processing per second, across all workers (0 = no limit) (esc)
+    -max-rate value
+      \tmaximum number of bytes to read per second, across all workers, with optional K/M/G/T/P suffix, like 50MB/s (0 = no limit) (esc)
     -n\tdry-run mode (do not write anything) (esc)
+    -oldest-first
+      \tprocess the files verified the longest ago first (requires -state); useful with -max-duration or -max-bytes (esc)
     -parallel int
-      \tnumber of files to process in parallel (0 = number of CPUs) (esc)
+      \tnumber of files to process in parallel on each device (0 = automatic: 1 for rotational disks, the number of CPUs otherwise) (esc)
+    -planrate uint
+      \texpected throughput for the plan command's estimate, in MiB/s (default 100) (esc)
+    -prescan
+      \tscan the paths before processing them, to show the overall progress and an ETA (esc)
     -q\tquiet mode (esc)
+    -rate-file string
+      \tread the rate limits from this file at startup and on SIGHUP, so they can be changed while running; it has one limit per line, like max-rate=50MB/s or max-files-rate=100 (esc)
+    -report string
+      \twrite a report of the run to this file (JSON if it ends in .json) (esc)
+    -reread int
+      \tnumber of times to re-read corrupted files, bypassing the cache, to check if the mismatch is consistent (esc)
+    -scrub-period string
+      \tsplit the files in one slot per day of this period (e.g. 30d), and only process today's slot, so all files are processed once per period (esc)
+    -scrub-slot int
+      \tslot to process with -scrub-period, instead of today's (from 0 to days-1) (default -1) (esc)
+    -sealed
+      \tsealed mode: report modified files as policy violations, and the ones where only the ctime changed as suspect (esc)
+    -shard string
+      \tonly process the files in shard i of n (given as i/n, with i from 0 to n-1), selected by their path relative to the root, so the shards are disjoint and cover all the files (esc)
+    -stale string
+      \tfor the stats command, list the files not verified in this long (e.g. 30d) (esc)
+    -state string
+      \tkeep track of when each file was last verified in this file (esc)
+    -strict
+      \tstrict verify: treat files without checksums as failures, and list them (use -sealed to also treat modified files as failures) (esc)
+    -subsetbytes
+      \tmake -subsetpct a percentage of the bytes instead of the files, so the amount of I/O is predictable (esc)
     -subsetpct uint
       \tpercentage of files to process (0 = none, 100 = all) (default 100) (esc)
     -subsetseed uint
@@ -75,20 +153,98 @@ Too few arguments.
         are left untouched, and checksums are not verified.
         Useful when generating checksums for a lot of files for the first time,
         as is faster to resume work if interrupted.
+    summer [flags] plan <paths>
+        Scan the given paths without reading the files, and print how much data
+        there is to process (per path and per device), and an estimate of how
+        long it would take (see -planrate).
+    summer [flags] stats <paths>
+        Print how many files there are in the given paths, and how long ago
+        they were verified (requires -state). With -stale, list the files not
+        verified in that long.
     summer [flags] version
         Print software version information.
   
+  Exit codes:
+  
+    0   Success.
+    1   The run was aborted due to an error, or invalid usage.
+  
+    Otherwise, the run completed (or was interrupted), and the exit code is a
+    combination (bitwise OR) of the following:
+  
+    2   Some files could not be processed due to errors (see -keep-going), or
+        could not be read due to I/O errors.
+    4   Corrupted files were detected.
+    8   Files without checksums were found (verify with -strict only).
+    16  Policy violations were detected (see -sealed).
+    32  The run was interrupted by SIGINT or SIGTERM, so not all files were
+        processed. The files in progress are completed before exiting.
+    64  Suspect files were detected (see -sealed): their contents changed, and
+        of their metadata only the ctime did. It could be corruption.
+  
+  Send SIGUSR1 (or SIGINFO, where available) to print the current status and
+  the files in progress. With -rate-file, send SIGHUP to reload the rate limits
+  from it.
+  
   Flags:
+    -checkpoint string
+      \tsave the progress to this file periodically, and if it exists, resume from it (skipping what was already done) (esc)
+    -device-parallel value
+      \tnumber of files to process in parallel on the device of a path, given as path=N, instead of -parallel (can be repeated) (esc)
     -exclude value
       \texclude these paths (can be repeated) (esc)
     -excludere value
       \texclude paths matching this regexp (can be repeated) (esc)
     -forcetty
       \tforce TTY output (esc)
+    -format string
+      \toutput format: text, or jsonl (one JSON object per line) (default "text") (esc)
+    -idle
+      \trun at idle I/O priority (where supported) and the lowest CPU priority, to reduce the impact on other processes (esc)
+    -iomode string
+      \thow to read files: cached (through the page cache), nocache (drop files from the page cache before and after), direct (bypass the page cache with direct I/O) (default "cached") (esc)
+    -keep-going
+      \tkeep going after errors, and list them at the end (esc)
+    -max-bytes value
+      \tstop handing out new files after this many bytes, with optional K/M/G/T/P suffix (0 = no limit); use with -checkpoint to continue from there on the next run (esc)
+    -max-duration duration
+      \tstop handing out new files after this long (0 = no limit); use with -checkpoint to continue from there on the next run (esc)
+    -max-files-rate float
+      \tmaximum number of files to start processing per second, across all workers (0 = no limit) (esc)
+    -max-rate value
+      \tmaximum number of bytes to read per second, across all workers, with optional K/M/G/T/P suffix, like 50MB/s (0 = no limit) (esc)
     -n\tdry-run mode (do not write anything) (esc)
+    -oldest-first
+      \tprocess the files verified the longest ago first (requires -state); useful with -max-duration or -max-bytes (esc)
     -parallel int
-      \tnumber of files to process in parallel (0 = number of CPUs) (esc)
+      \tnumber of files to process in parallel on each device (0 = automatic: 1 for rotational disks, the number of CPUs otherwise) (esc)
+    -planrate uint
+      \texpected throughput for the plan command's estimate, in MiB/s (default 100) (esc)
+    -prescan
+      \tscan the paths before processing them, to show the overall progress and an ETA (esc)
     -q\tquiet mode (esc)
+    -rate-file string
+      \tread the rate limits from this file at startup and on SIGHUP, so they can be changed while running; it has one limit per line, like max-rate=50MB/s or max-files-rate=100 (esc)
+    -report string
+      \twrite a report of the run to this file (JSON if it ends in .json) (esc)
+    -reread int
+      \tnumber of times to re-read corrupted files, bypassing the cache, to check if the mismatch is consistent (esc)
+    -scrub-period string
+      \tsplit the files in one slot per day of this period (e.g. 30d), and only process today's slot, so all files are processed once per period (esc)
+    -scrub-slot int
+      \tslot to process with -scrub-period, instead of today's (from 0 to days-1) (default -1) (esc)
+    -sealed
+      \tsealed mode: report modified files as policy violations, and the ones where only the ctime changed as suspect (esc)
+    -shard string
+      \tonly process the files in shard i of n (given as i/n, with i from 0 to n-1), selected by their path relative to the root, so the shards are disjoint and cover all the files (esc)
+    -stale string
+      \tfor the stats command, list the files not verified in this long (e.g. 30d) (esc)
+    -state string
+      \tkeep track of when each file was last verified in this file (esc)
+    -strict
+      \tstrict verify: treat files without checksums as failures, and list them (use -sealed to also treat modified files as failures) (esc)
+    -subsetbytes
+      \tmake -subsetpct a percentage of the bytes instead of the files, so the amount of I/O is predictable (esc)
     -subsetpct uint
       \tpercentage of files to process (0 = none, 100 = all) (default 100) (esc)
     -subsetseed uint
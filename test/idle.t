@@ -0,0 +1,24 @@
+Tests for running at idle priority.
+
+  $ alias summer="$TESTDIR/../summer"
+  $ mkdir D
+  $ for i in 1 2 3 4; do head -c 1024 /dev/zero > D/f$i; done
+  $ summer -q generate D
+
+The status says it's running at idle priority.
+
+  $ summer -idle verify D
+  0s: 4 matched, 0 modified, 0 new, 0 corrupted, at idle priority
+  $ summer -idle -format=jsonl verify D | grep summary | grep -o '"idle_priority":true'
+  "idle_priority":true
+
+All the threads have the lowest CPU priority (field 19 of their stat), and the
+idle I/O class. Use a slow run so we can look at them while it's running.
+
+  $ summer -idle -max-files-rate=2 verify D > /dev/null &
+  $ sleep 0.5
+  $ cat /proc/$!/task/*/stat | awk '{ print $19 }' | sort -u
+  19
+  $ for t in /proc/$!/task/*; do ionice -p `basename $t`; done | sort -u
+  idle
+  $ wait $!
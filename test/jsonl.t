@@ -0,0 +1,50 @@
+Tests for the JSON lines output format.
+
+  $ alias summer="$TESTDIR/../summer"
+
+  $ echo marola > hola
+  $ touch "$(printf 'bad\377name')"
+
+Use --parallel=1 to ensure reproducible output.
+
+  $ summer -parallel=1 -format=jsonl update .
+  {"event":"new","path":"bad�name","path_b64":"YmFk/25hbWU=","new":{"crc32c":"00000000","mtime_usec":\d+,"size":0},"duration_sec":[0-9.e-]+} (re)
+  {"event":"new","path":"hola","new":{"crc32c":"239059f6","mtime_usec":\d+,"size":7},"duration_sec":[0-9.e-]+} (re)
+  {"event":"summary","duration_sec":[0-9.e-]+,"matched":0,"modified":0,"preserved_mtime":0,"new":2,"corrupted":0,"suspect":0,"violations":0,"unreadable":0,"changed":0,"errors":0,"exit_code":0} (re)
+
+  $ summer -parallel=1 -format=jsonl verify .
+  {"event":"matched","path":"bad�name","path_b64":"YmFk/25hbWU=","new":{"crc32c":"00000000","mtime_usec":\d+,"size":0},"duration_sec":[0-9.e-]+} (re)
+  {"event":"matched","path":"hola","new":{"crc32c":"239059f6","mtime_usec":\d+,"size":7},"duration_sec":[0-9.e-]+} (re)
+  {"event":"summary","duration_sec":[0-9.e-]+,"matched":2,"modified":0,"preserved_mtime":0,"new":0,"corrupted":0,"suspect":0,"violations":0,"unreadable":0,"changed":0,"errors":0,"exit_code":0} (re)
+
+Problems are reported in the summary, instead of as text.
+
+  $ sleep 0.005
+  $ echo sospechoso >> hola
+  $ touch nueva
+  $ summer -parallel=1 -format=jsonl -keep-going verify doesnotexist hola nueva
+  {"event":"error","path":"doesnotexist","class":"not found","error":"lstat doesnotexist: no such file or directory"}
+  {"event":"modified","path":"hola","old":{"crc32c":"239059f6","mtime_usec":\d+,"size":7},"new":{"crc32c":"916db13f","mtime_usec":\d+,"size":18},"duration_sec":[0-9.e-]+} (re)
+  {"event":"new","path":"nueva","duration_sec":[0-9.e-]+} (re)
+  {"event":"summary","duration_sec":[0-9.e-]+,"matched":0,"modified":1,"preserved_mtime":0,"new":1,"corrupted":0,"suspect":0,"violations":0,"unreadable":0,"changed":0,"errors":1,"exit_code":2,"messages":\["completed with 1 errors"\]} (re)
+  [2]
+
+  $ summer -format=jsonl verify doesnotexist
+  {"event":"summary","duration_sec":[0-9.e-]+,"matched":0,"modified":0,"preserved_mtime":0,"new":0,"corrupted":0,"suspect":0,"violations":0,"unreadable":0,"changed":0,"errors":0,"exit_code":1,"messages":\["lstat doesnotexist: no such file or directory"\]} (re)
+  [1]
+
+Errors saving the results at the end are in the summary too.
+
+  $ mkdir two
+  $ echo a > two/a; echo b > two/b
+  $ summer -format=jsonl -parallel=1 -max-bytes=1 -checkpoint=doesnotexist/cp \
+  >   verify two
+  {"event":"new","path":"two/a","duration_sec":[0-9.e-]+} (re)
+  {"event":"summary","duration_sec":[0-9.e-]+,"matched":0,"modified":0,"preserved_mtime":0,"new":1,"corrupted":0,"suspect":0,"violations":0,"unreadable":0,"changed":0,"errors":0,"exit_code":1,"messages":\["byte limit reached, not all files were processed","error saving checkpoint: open doesnotexist/cp.tmp: no such file or directory"\]} (re)
+  [1]
+
+Check format validation.
+
+  $ summer -format=invalid verify .
+  unknown output format "invalid"
+  [1]
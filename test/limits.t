@@ -0,0 +1,74 @@
+Tests for the time and byte limits of a run.
+
+  $ alias summer="$TESTDIR/../summer"
+  $ mkdir D
+  $ for i in 1 2 3 4; do head -c 1024 /dev/zero > D/f$i; done
+  $ summer -q generate D
+
+When the byte limit is reached, no new files are processed. It is not an
+error, but it's noted at the end.
+
+  $ summer --parallel=1 -v -max-bytes=2048 verify D
+  "D/f1": match \(checksum:[0-9a-f]+, mtime:\d+\) (re)
+  "D/f2": match \(checksum:[0-9a-f]+, mtime:\d+\) (re)
+  0s: 2 matched, 0 modified, 0 new, 0 corrupted
+  byte limit reached, not all files were processed
+
+With a checkpoint, the next run continues where the previous one stopped.
+Once a run completes, the checkpoint is removed, so the following one starts
+from the beginning.
+
+  $ summer --parallel=1 -v -max-bytes=1K -checkpoint=cp verify D
+  "D/f1": match \(checksum:[0-9a-f]+, mtime:\d+\) (re)
+  0s: 1 matched, 0 modified, 0 new, 0 corrupted
+  byte limit reached, not all files were processed
+  $ summer --parallel=1 -v -max-bytes=2K -checkpoint=cp verify D
+  resuming from checkpoint "cp", skipping up to "D/f1"
+  "D/f2": match \(checksum:[0-9a-f]+, mtime:\d+\) (re)
+  "D/f3": match \(checksum:[0-9a-f]+, mtime:\d+\) (re)
+  0s: 2 matched, 0 modified, 0 new, 0 corrupted
+  byte limit reached, not all files were processed
+  $ summer --parallel=1 -v -max-bytes=2K -checkpoint=cp verify D
+  resuming from checkpoint "cp", skipping up to "D/f3"
+  "D/f4": match \(checksum:[0-9a-f]+, mtime:\d+\) (re)
+  0s: 1 matched, 0 modified, 0 new, 0 corrupted
+  $ test -e cp
+  [1]
+  $ summer --parallel=1 -max-bytes=2K -checkpoint=cp verify D
+  0s: 2 matched, 0 modified, 0 new, 0 corrupted
+  byte limit reached, not all files were processed
+
+Same for the time limit.
+
+  $ summer -max-duration=1ns verify D
+  0s: 0 matched, 0 modified, 0 new, 0 corrupted
+  time limit reached, not all files were processed
+
+The time limit is checked while waiting for a busy worker too, so we don't
+start on another file after it.
+
+  $ mkdir E
+  $ for i in 1 2 3; do head -c 64K /dev/zero > E/f$i; done
+  $ summer -q generate E
+  $ summer --parallel=1 -v -max-rate=32K -max-duration=100ms -checkpoint=cpE \
+  >   verify E
+  "E/f1": match \(checksum:[0-9a-f]+, mtime:\d+\) (re)
+  \ds: 1 matched, 0 modified, 0 new, 0 corrupted (re)
+  time limit reached, not all files were processed
+  $ summer --parallel=1 -v -checkpoint=cpE verify E
+  resuming from checkpoint "cpE", skipping up to "E/f1"
+  "E/f2": match \(checksum:[0-9a-f]+, mtime:\d+\) (re)
+  "E/f3": match \(checksum:[0-9a-f]+, mtime:\d+\) (re)
+  0s: 2 matched, 0 modified, 0 new, 0 corrupted
+
+The limit doesn't hide other problems.
+
+  $ touch D/a-new
+  $ summer -max-bytes=1 verify D
+  0s: 1 matched, 0 modified, 1 new, 0 corrupted
+  byte limit reached, not all files were processed
+
+Invalid limits.
+
+  $ summer -max-bytes=lots verify D 2>&1 | head -n 1
+  invalid value "lots" for flag -max-bytes: invalid size "lots"
@@ -55,6 +55,33 @@ Check how we handle getting an error in the middle.
   [1]
 
   $ summer -v generate A B C
+  device \d+:\d+: \d+ in parallel \(.*\) (re)
   0s: 0 matched, 0 modified, 0 new, 0 corrupted
   open B/b1: permission denied
   [1]
+
+With -keep-going, errors are recorded and we continue with the rest of the
+files. They are listed at the end, and the exit code reflects that the run
+completed with errors.
+
+  $ summer --parallel=1 -v -keep-going verify A B C doesnotexist
+  "A/a1": match \(checksum:0, mtime:\d+\) (re)
+  "A/a2": match \(checksum:0, mtime:\d+\) (re)
+  "B/b2": match \(checksum:0, mtime:\d+\) (re)
+  "C/c1": match \(checksum:0, mtime:\d+\) (re)
+  0s: 4 matched, 0 modified, 0 new, 0 corrupted, 2 errors
+  errors:
+    "B/b1": permission denied: open B/b1: permission denied
+    "doesnotexist": not found: lstat doesnotexist: no such file or directory
+  completed with 2 errors
+  [2]
+
+  $ chmod 0000 C
+  $ summer -keep-going update A B C
+  0s: 3 matched, 0 modified, 0 new, 0 corrupted, 2 errors
+  errors:
+    "B/b1": permission denied: open B/b1: permission denied
+    "C": permission denied: open C: permission denied
+  completed with 2 errors
+  [2]
+  $ chmod 0755 C
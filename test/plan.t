@@ -0,0 +1,42 @@
+Tests for the plan command, which scans the paths without reading them.
+
+  $ alias summer="$TESTDIR/../summer"
+
+  $ mkdir A B
+  $ echo marola > A/hola
+  $ head -c 2048 /dev/zero > A/zeros
+  $ touch B/empty
+  $ ln -s hola A/link
+
+  $ summer plan A B
+  "A": 2 files, 2.0 KiB
+  "B": 1 files, 0 B
+  device \d+:\d+: 3 files, 2.0 KiB (re)
+  total: 3 files, 2.0 KiB
+  would take ~0s at 100.0 MiB/s
+
+Exclusions are taken into account.
+
+  $ summer -exclude=A/zeros plan A B
+  "A": 1 files, 7 B
+  "B": 1 files, 0 B
+  device \d+:\d+: 2 files, 7 B (re)
+  total: 2 files, 7 B
+  would take ~0s at 100.0 MiB/s
+
+Nothing is written.
+
+  $ summer verify A B
+  0s: 0 matched, 0 modified, 3 new, 0 corrupted
+
+The estimate uses the given throughput.
+
+  $ head -c 3000000 /dev/zero > B/big
+  $ summer -q -planrate=1 plan B
+  $ summer -planrate=1 plan B | tail -n 1
+  would take ~3s at 1.0 MiB/s
+
+Prescanning does not change the output when not on a TTY.
+
+  $ summer -prescan update A B
+  0s: 0 matched, 0 modified, 4 new, 0 corrupted
@@ -0,0 +1,42 @@
+Tests for the rolling scrub schedule.
+
+  $ alias summer="$TESTDIR/../summer"
+
+  $ mkdir dir1 dir2
+  $ for i in 1 2 3 4 5 6 7 8 9; do touch dir1/f$i dir2/f$i; done
+
+Each file is in exactly one slot, so processing all the slots covers all the
+files once.
+
+  $ for i in 0 1 2; do
+  >   summer -scrub-period=3d -scrub-slot=$i -v generate dir1 dir2 | grep -c writing
+  > done > counts
+  $ awk '{ s += $1 } END { print s }' counts
+  18
+  $ summer verify dir1 dir2
+  0s: 18 matched, 0 modified, 0 new, 0 corrupted
+
+The slots are based on the absolute path, so they don't depend on how the
+paths are given.
+
+  $ summer -scrub-period=3d -scrub-slot=1 -v verify dir1 dir2 | grep match | sort > a
+  $ summer -scrub-period=3d -scrub-slot=1 -v verify $PWD/dir1 $PWD/dir2 | grep match \
+  >   | sed "s|$PWD/||" | sort > b
+  $ cmp a b
+
+Without -scrub-slot, today's slot is used.
+
+  $ summer -scrub-period=1d verify dir1 dir2
+  0s: 18 matched, 0 modified, 0 new, 0 corrupted
+
+Invalid values.
+
+  $ summer -scrub-period=0d verify .
+  period "0d" must be at least one day
+  [1]
+  $ summer -scrub-period=3x verify .
+  invalid period "3x"
+  [1]
+  $ summer -scrub-period=3d -scrub-slot=3 verify .
+  scrub slot 3 must be in the [0, 2] range
+  [1]
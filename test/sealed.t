@@ -0,0 +1,74 @@
+Tests for sealed mode, where files are not expected to change.
+
+  $ alias summer="$TESTDIR/../summer"
+
+Generate test data.
+
+  $ touch empty
+  $ echo marola > hola
+
+  $ summer -sealed generate .
+  0s: 0 matched, 0 modified, 2 new, 0 corrupted, 0 violations
+  $ summer -sealed verify .
+  0s: 2 matched, 0 modified, 0 new, 0 corrupted, 0 violations
+
+Modify a file. It should be reported as a policy violation, both in verify and
+update.
+
+  $ sleep 0.005
+  $ echo sospechoso >> hola
+  $ summer -sealed verify .
+  "hola": POLICY VIOLATION - sealed file modified \(checksum: 239059f6 -> 916db13f, mtime: \d+ -> \d+\) (re)
+  0s: 1 matched, 0 modified, 0 new, 0 corrupted, 1 violations
+  policy violations:
+    "hola": sealed file modified \(checksum: 239059f6 -> 916db13f, mtime: \d+ -> \d+\) (re)
+  detected 1 policy violations
+  [16]
+  $ summer -sealed update .
+  "hola": POLICY VIOLATION - sealed file modified \(checksum: 239059f6 -> 916db13f, mtime: \d+ -> \d+\) (re)
+  0s: 1 matched, 0 modified, 0 new, 0 corrupted, 1 violations
+  policy violations:
+    "hola": sealed file modified \(checksum: 239059f6 -> 916db13f, mtime: \d+ -> \d+\) (re)
+  detected 1 policy violations
+  [16]
+
+The old checksum must have been kept, so the violation is still reported.
+
+  $ summer -sealed update .
+  "hola": POLICY VIOLATION - sealed file modified \(checksum: 239059f6 -> 916db13f, mtime: \d+ -> \d+\) (re)
+  0s: 1 matched, 0 modified, 0 new, 0 corrupted, 1 violations
+  policy violations:
+    "hola": sealed file modified \(checksum: 239059f6 -> 916db13f, mtime: \d+ -> \d+\) (re)
+  detected 1 policy violations
+  [16]
+
+Modifications that preserve the mtime are violations too, and they show the
+size change instead.
+
+  $ echo marola > sospechoso
+  $ summer -sealed -q generate sospechoso
+  $ OLD_MTIME=`stat -c "%y" sospechoso`
+  $ echo trova >> sospechoso
+  $ touch --date="$OLD_MTIME" sospechoso
+  $ summer -sealed verify sospechoso
+  "sospechoso": POLICY VIOLATION - sealed file modified with preserved mtime (checksum: 239059f6 -> 5ad5d391, size: 7 -> 13)
+  0s: 0 matched, 0 modified, 0 new, 0 corrupted, 1 violations
+  policy violations:
+    "sospechoso": sealed file modified with preserved mtime (checksum: 239059f6 -> 5ad5d391, size: 7 -> 13)
+  detected 1 policy violations
+  [16]
+  $ rm sospechoso
+
+New files are allowed.
+
+  $ echo trova > nueva
+  $ summer -sealed -q update nueva
+  $ summer -sealed verify nueva
+  0s: 1 matched, 0 modified, 0 new, 0 corrupted, 0 violations
+
+Without sealed mode, the modification is accepted as usual.
+
+  $ summer update .
+  0s: 2 matched, 1 modified, 0 new, 0 corrupted
+  $ summer -sealed verify .
+  0s: 3 matched, 0 modified, 0 new, 0 corrupted, 0 violations
@@ -0,0 +1,39 @@
+Tests for sharding.
+
+  $ alias summer="$TESTDIR/../summer"
+
+  $ mkdir dir1 dir2
+  $ for i in 1 2 3 4 5 6 7 8 9; do touch dir1/f$i dir2/f$i; done
+
+Each file is in exactly one shard, so processing all the shards covers all the
+files once.
+
+  $ for i in 0 1 2; do
+  >   summer -shard=$i/3 -v generate dir1 dir2 | grep -c writing
+  > done > counts
+  $ awk '{ s += $1 } END { print s }' counts
+  18
+  $ summer verify dir1 dir2
+  0s: 18 matched, 0 modified, 0 new, 0 corrupted
+
+The shards are based on the path relative to the root, so they are the same
+when the tree is found somewhere else.
+
+  $ summer -shard=1/3 -v verify dir1 | grep match | sed "s|dir1/||" | sort > a
+  $ cp -a dir1 moved
+  $ summer -shard=1/3 -v verify moved | grep match | sed "s|moved/||" | sort > b
+  $ cmp a b
+
+The plan takes them into account.
+
+  $ summer -shard=1/3 plan dir1 dir2 | grep total > plan
+  $ test "`cat plan`" = "total: `sed -n 2p counts` files, 0 B"
+
+Invalid values.
+
+  $ summer -shard=3/3 verify .
+  shard "3/3" must have i in the [0, n-1] range
+  [1]
+  $ summer -shard=1 verify .
+  invalid shard "1", expected i/n
+  [1]
@@ -5,6 +5,7 @@ Test that summer works fine when given a file instead of a directory.
   $ echo marola > hola
 
   $ summer -v generate ./empty
+  device \d+:\d+: \d+ in parallel \(.*\) (re)
   "./empty": writing checksum \(checksum:0, mtime:\d+\) (re)
   0s: 0 matched, 0 modified, 1 new, 0 corrupted
 
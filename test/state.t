@@ -0,0 +1,87 @@
+Tests for keeping track of when files were last verified.
+
+  $ alias summer="$TESTDIR/../summer"
+  $ mkdir D
+  $ for f in a b c; do head -c 1024 /dev/zero > D/$f; done
+  $ summer -q generate D
+
+Write a state file, as if "b" had been verified long ago, "a" less so, and "c"
+never.
+
+  $ cat > st <<EOT
+  > summer state v1
+  > 1699963200 "$PWD/D/a"
+  > 1000036800 "$PWD/D/b"
+  > EOT
+
+  $ summer -state=st stats D
+  3 files, 1 never verified, oldest verified 2001-09-09 \(\d+ days ago\) (re)
+  $ summer -state=st -stale=30d stats D
+  "D/a": last verified 2023-11-14 \(\d+ days ago\) (re)
+  "D/b": last verified 2001-09-09 \(\d+ days ago\) (re)
+  "D/c": never verified
+  3 files, 1 never verified, oldest verified 2001-09-09 \(\d+ days ago\), 3 not verified in 30 days (re)
+
+With -oldest-first, the files that went the longest without verification are
+processed first, so with a limit, each run makes progress on the oldest ones.
+
+  $ summer -state=st -oldest-first -max-bytes=1K -parallel=1 -v verify D
+  "D/c": match \(checksum:[0-9a-f]+, mtime:\d+\) (re)
+  0s: 1 matched, 0 modified, 0 new, 0 corrupted
+  byte limit reached, not all files were processed
+  $ summer -state=st -oldest-first -max-bytes=1K -parallel=1 -v verify D
+  "D/b": match \(checksum:[0-9a-f]+, mtime:\d+\) (re)
+  0s: 1 matched, 0 modified, 0 new, 0 corrupted
+  byte limit reached, not all files were processed
+
+  $ summer -state=st -stale=30d stats D
+  "D/a": last verified 2023-11-14 \(\d+ days ago\) (re)
+  3 files, 0 never verified, oldest verified 2023-11-14 \(\d+ days ago\), 1 not verified in 30 days (re)
+
+Corrupted files don't count as verified.
+
+  $ touch --date=@1700000000 D/a
+  $ xattr -w -x user.summer-v1 f659902300401e18240a0600 D/a
+  $ summer -state=st -q verify D
+  detected 1 corrupted files
+  [4]
+  $ summer -state=st -stale=30d stats D
+  "D/a": last verified 2023-11-14 \(\d+ days ago\) (re)
+  3 files, 0 never verified, oldest verified 2023-11-14 \(\d+ days ago\), 1 not verified in 30 days (re)
+
+Nothing is written in dry-run mode.
+
+  $ summer -n -state=st2 verify D
+  "D/a": FILE CORRUPTED - expected:239059f6, got:eeaede7c
+  0s: 2 matched, 0 modified, 0 new, 1 corrupted
+  corrupted files:
+    "D/a": expected:239059f6, got:eeaede7c
+  detected 1 corrupted files
+  [4]
+  $ test -e st2
+  [1]
+
+Modified files don't count as verified either, because their checksums are
+not updated.
+
+  $ echo nuevo > D/a
+  $ summer -state=st -q verify D
+  $ summer -state=st -stale=30d stats D
+  "D/a": last verified 2023-11-14 \(\d+ days ago\) (re)
+  3 files, 0 never verified, oldest verified 2023-11-14 \(\d+ days ago\), 1 not verified in 30 days (re)
+
+Invalid combinations.
+
+  $ summer -oldest-first verify D
+  -oldest-first requires -state
+  [1]
+  $ summer -state=st -oldest-first -checkpoint=cp verify D
+  -oldest-first can't be used with -checkpoint (the state already tracks the progress)
+  [1]
+  $ summer stats D
+  stats needs a state file (see -state)
+  [1]
+  $ echo lalala > st
+  $ summer -state=st verify D
+  "st": unknown format
+  [1]
@@ -0,0 +1,53 @@
+Tests for strict verification, where files without checksums are failures.
+
+  $ alias summer="$TESTDIR/../summer"
+
+  $ mkdir dir
+  $ touch empty dir/empty
+  $ echo marola > hola
+  $ summer -q generate .
+
+When everything has a checksum, strict mode is just like normal verify.
+
+  $ summer -strict verify .
+  0s: 3 matched, 0 modified, 0 new, 0 corrupted
+
+Without -strict, files without checksums are counted as new, but they are
+not failures.
+
+  $ touch zzz dir/nueva
+  $ summer verify .
+  0s: 3 matched, 0 modified, 2 new, 0 corrupted
+
+With it, they are listed, and reported as failures.
+
+  $ summer -strict verify .
+  0s: 3 matched, 0 modified, 2 new, 0 corrupted
+  files without checksums:
+    "dir/nueva"
+    "zzz"
+  found 2 files without checksums
+  [8]
+
+Combined with sealed mode, modified files are also failures.
+
+  $ sleep 0.005
+  $ echo sospechoso >> hola
+  $ summer -strict -sealed verify .
+  "hola": POLICY VIOLATION - sealed file modified \(checksum: 239059f6 -> 916db13f, mtime: \d+ -> \d+\) (re)
+  0s: 2 matched, 0 modified, 2 new, 0 corrupted, 1 violations
+  policy violations:
+    "hola": sealed file modified \(checksum: 239059f6 -> 916db13f, mtime: \d+ -> \d+\) (re)
+  files without checksums:
+    "dir/nueva"
+    "zzz"
+  detected 1 policy violations
+  found 2 files without checksums
+  [24]
+
+Strict mode has no effect on update, because it adds the missing checksums.
+
+  $ summer -strict update .
+  0s: 2 matched, 1 modified, 2 new, 0 corrupted
+  $ summer -strict verify .
+  0s: 5 matched, 0 modified, 0 new, 0 corrupted
@@ -51,3 +51,14 @@ Test that with a 0% subset, no files are included.
 
   $ summer -subsetpct=0 verify .
   0s: 0 matched, 0 modified, 0 new, 0 corrupted
+
+With -subsetbytes, the percentage is of the bytes. Files with no contents are
+picked by count, so we use files with the same size to check it.
+
+  $ mkdir bytes
+  $ for i in `seq 20`; do head -c 1000 /dev/zero > bytes/f$i; done
+  $ summer -v -subsetbytes -subsetpct=50 -parallel=1 verify bytes \
+  >   | grep -c "missing checksum"
+  10
+  $ summer -subsetbytes -subsetpct=0 verify bytes
+  0s: 0 matched, 0 modified, 0 new, 0 corrupted
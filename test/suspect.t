@@ -0,0 +1,47 @@
+Tests for files whose contents changed, and of their metadata only the ctime
+did. That's what happens when a tool like "cp -p" replaces a file with one of
+the same size, so it's a legitimate modification. But many things change the
+ctime without touching the contents (chmod, renames, xattrs, ...), so in
+sealed mode these are reported as suspect, since they could be corruption.
+
+  $ alias summer="$TESTDIR/../summer"
+
+  $ echo marola > file
+  $ summer -q generate file
+
+Change the contents keeping the size and mtime. The ctime needs to move past
+the slack (1s) for it to count.
+
+  $ OLD_MTIME=`stat -c "%y" file`
+  $ sleep 1.1
+  $ echo marolo > file
+  $ touch --date="$OLD_MTIME" file
+
+In sealed mode, it's suspect, and update keeps the old checksum.
+
+  $ summer -sealed verify file
+  "file": SUSPECT - contents changed but only the ctime did, possible corruption \(checksum: 239059f6 -> [0-9a-f]+, ctime: \d+ -> \d+\) (re)
+  0s: 0 matched, 0 modified, 0 new, 0 corrupted, 1 suspect, 0 violations
+  suspect files:
+    "file": contents changed but only the ctime did, possible corruption \(checksum: 239059f6 -> [0-9a-f]+, ctime: \d+ -> \d+\) (re)
+  detected 1 suspect files
+  [64]
+  $ summer -sealed update file
+  "file": SUSPECT - contents changed but only the ctime did, possible corruption \(checksum: 239059f6 -> [0-9a-f]+, ctime: \d+ -> \d+\) (re)
+  0s: 0 matched, 0 modified, 0 new, 0 corrupted, 1 suspect, 0 violations
+  suspect files:
+    "file": contents changed but only the ctime did, possible corruption \(checksum: 239059f6 -> [0-9a-f]+, ctime: \d+ -> \d+\) (re)
+  detected 1 suspect files
+  [64]
+
+Otherwise, it's a modification with the mtime preserved, and update accepts
+the new contents.
+
+  $ summer verify file
+  "file": file modified with preserved mtime \(not corrupted\) \(checksum: 239059f6 -> [0-9a-f]+, size: 7 -> 7\) (re)
+  0s: 0 matched, 0 modified, 0 new, 0 corrupted, 1 modified with preserved mtime
+  $ summer update file
+  "file": file modified with preserved mtime \(not corrupted\) \(checksum: 239059f6 -> [0-9a-f]+, size: 7 -> 7\) (re)
+  0s: 0 matched, 0 modified, 0 new, 0 corrupted, 1 modified with preserved mtime
+  $ summer verify file
+  0s: 1 matched, 0 modified, 0 new, 0 corrupted
@@ -0,0 +1,73 @@
+Tests for the rate limits.
+
+  $ alias summer="$TESTDIR/../summer"
+  $ mkdir D
+  $ for i in 1 2 3 4; do head -c 100000 /dev/zero > D/f$i; done
+  $ summer -q generate D
+
+Helper to run summer and check that it took at least the given number of
+milliseconds.
+
+  $ atleast() {
+  >   ms=$1; shift
+  >   start=`date +%s%N`
+  >   "$TESTDIR/../summer" "$@"
+  >   took=$(( (`date +%s%N` - start) / 1000000 ))
+  >   test $took -ge $ms || echo "took ${took}ms, expected at least ${ms}ms"
+  > }
+
+The first second's worth is available right away, after that we read at the
+given rate.
+
+  $ atleast 900 -max-rate=200000B/s verify D
+  \ds: 4 matched, 0 modified, 0 new, 0 corrupted (re)
+  $ atleast 900 -max-files-rate=2 verify D
+  \ds: 4 matched, 0 modified, 0 new, 0 corrupted (re)
+
+Limits can be given in a file, which takes precedence over the flags.
+
+  $ echo "max-files-rate=2" > rates
+  $ atleast 900 -rate-file=rates -max-files-rate=100 verify D
+  \ds: 4 matched, 0 modified, 0 new, 0 corrupted (re)
+
+And changed while running, by sending SIGHUP. Start very slow, and remove the
+limits once it's running.
+
+  $ echo "max-rate=1K" > rates
+  $ summer -rate-file=rates verify D > out &
+  $ sleep 0.5
+  $ echo "# No limits." > rates
+  $ kill -HUP $!
+  $ wait $!
+  $ cat out
+  rate limits changed: no byte limit, no file limit
+  \ds: 4 matched, 0 modified, 0 new, 0 corrupted (re)
+
+Errors in the file are reported, and the limits are kept.
+
+  $ echo "max-rate=lalala" > rates
+  $ summer -rate-file=rates verify D
+  error loading rate limits: "rates":1: invalid rate "lalala"
+  [1]
+
+  $ echo "max-rate=1K" > rates
+  $ summer -rate-file=rates verify D > out &
+  $ sleep 0.5
+  $ echo "max-rate=lalala" > rates
+  $ kill -HUP $!
+  $ sleep 0.5
+  $ echo "max-rate=100M" > rates
+  $ kill -HUP $!
+  $ wait $!
+  $ cat out
+  error reloading rate limits, keeping them: "rates":1: invalid rate "lalala"
+  rate limits changed: 100.0 MiB/s, no file limit
+  \ds: 4 matched, 0 modified, 0 new, 0 corrupted (re)
+
+Invalid values.
+
+  $ summer -max-rate=lalala verify D 2>&1 | head -n 1
+  invalid value "lalala" for flag -max-rate: invalid rate "lalala"
+  $ summer -max-files-rate=-1 verify D
+  invalid -max-files-rate -1
+  [1]
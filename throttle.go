@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Flags.
+var (
+	maxRate      = new(RateFlag)
+	maxFilesRate = flag.Float64("max-files-rate", 0,
+		"maximum number of files to start processing per second, across "+
+			"all workers (0 = no limit)")
+	rateFile = flag.String("rate-file", "",
+		"read the rate limits from this file at startup and on SIGHUP, so "+
+			"they can be changed while running; it has one limit per line, "+
+			"like max-rate=50MB/s or max-files-rate=100")
+)
+
+// Limits on how fast we read, shared by all the workers, so we don't saturate
+// the storage (see -max-rate and -max-files-rate).
+type throttle struct {
+	bytes tokenBucket
+	files tokenBucket
+}
+
+func newThrottle(bytesRate int64, filesRate float64) *throttle {
+	t := &throttle{}
+	t.SetRates(bytesRate, filesRate)
+	return t
+}
+
+// Set the rates, in bytes and files per second (0 = no limit).
+func (t *throttle) SetRates(bytesRate int64, filesRate float64) {
+	t.bytes.SetRate(float64(bytesRate))
+	t.files.SetRate(filesRate)
+}
+
+// Wait until we can read n more bytes.
+func (t *throttle) WaitBytes(n int) {
+	t.bytes.Wait(float64(n))
+}
+
+// Wait until we can start processing another file.
+func (t *throttle) WaitFile() {
+	t.files.Wait(1)
+}
+
+// Token bucket: tokens are added at a constant rate, up to one second's worth,
+// and taken as they're used. Taking more tokens than there are leaves the
+// bucket in debt, and the caller waits until it's paid off. That way big
+// requests don't need to be split.
+type tokenBucket struct {
+	mu sync.Mutex
+
+	// Tokens added per second (0 = no limit).
+	rate float64
+
+	// Tokens available (negative if in debt), as of last.
+	tokens float64
+	last   time.Time
+
+	// Closed when the rate changes, to stop the waits.
+	changed chan struct{}
+}
+
+// Change the rate. The bucket starts full, and the current waits are stopped,
+// so they don't wait according to the old rate.
+func (b *tokenBucket) SetRate(rate float64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rate = rate
+	b.tokens = rate
+	b.last = time.Now()
+	if b.changed != nil {
+		close(b.changed)
+	}
+	b.changed = make(chan struct{})
+}
+
+// Take n tokens, and wait until they're available (or the rate changes).
+func (b *tokenBucket) Wait(n float64) {
+	d, changed := b.reserve(n, time.Now())
+	if d <= 0 {
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-changed:
+	}
+}
+
+// Take n tokens at the given time, and return how long to wait until they're
+// available, and a channel that is closed if the rate changes.
+func (b *tokenBucket) reserve(n float64, now time.Time) (time.Duration, chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rate <= 0 {
+		return 0, b.changed
+	}
+
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = min(b.rate, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+
+	b.tokens -= n
+	if b.tokens >= 0 {
+		return 0, b.changed
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second)), b.changed
+}
+
+// Load the rate limits from the given file (see -rate-file). Limits that are
+// not in the file are removed.
+func loadRates(file string) (int64, float64, error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer fd.Close()
+
+	bytesRate, filesRate := RateFlag(0), 0.0
+	scanner := bufio.NewScanner(fd)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, value, _ := strings.Cut(line, "=")
+		switch strings.TrimSpace(name) {
+		case "max-rate":
+			err = bytesRate.Set(strings.TrimSpace(value))
+		case "max-files-rate":
+			filesRate, err = strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err == nil && filesRate < 0 {
+				err = fmt.Errorf("invalid rate %q", value)
+			}
+		default:
+			err = fmt.Errorf("unknown limit %q", name)
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("%q:%d: %v", file, n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+	return int64(bytesRate), filesRate, nil
+}
+
+// Reload the rate limits from -rate-file, after SIGHUP.
+func reloadRates(p *Progress) {
+	bytesRate, filesRate, err := loadRates(options.rateFile)
+	if err != nil {
+		p.PrintNote("error reloading rate limits, keeping them: %v", err)
+		return
+	}
+	options.throttle.SetRates(bytesRate, filesRate)
+	p.PrintNote("rate limits changed: %s", describeRates(bytesRate, filesRate))
+}
+
+// Describe the rate limits, for the user.
+func describeRates(bytesRate int64, filesRate float64) string {
+	s := "no byte limit"
+	if bytesRate > 0 {
+		s = humanBytes(bytesRate) + "/s"
+	}
+	if filesRate > 0 {
+		s += fmt.Sprintf(", %g files/s", filesRate)
+	} else {
+		s += ", no file limit"
+	}
+	return s
+}
+
+// Flag for a rate in bytes per second. It's a byte size (see ByteSizeFlag),
+// optionally with a "B" and a "/s" suffix, like "50MB/s".
+type RateFlag int64
+
+func (f *RateFlag) String() string {
+	return fmt.Sprintf("%d", *f)
+}
+
+func (f *RateFlag) Set(value string) error {
+	v := strings.TrimSuffix(value, "/s")
+	v = strings.TrimSuffix(v, "B")
+
+	var size ByteSizeFlag
+	if err := size.Set(v); err != nil {
+		return fmt.Errorf("invalid rate %q", value)
+	}
+	*f = RateFlag(size)
+	return nil
+}
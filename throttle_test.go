@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	b := &tokenBucket{}
+	start := time.Now()
+	b.SetRate(100)
+	b.last = start
+
+	at := func(secs float64) time.Time {
+		return start.Add(time.Duration(secs * float64(time.Second)))
+	}
+	check := func(n float64, now time.Time, expected time.Duration) {
+		t.Helper()
+		if d, _ := b.reserve(n, now); d != expected {
+			t.Errorf("reserve(%v) = %v, expected %v", n, d, expected)
+		}
+	}
+
+	// The bucket starts full, with one second's worth.
+	check(100, start, 0)
+
+	// Now it's empty, so we go into debt, and must wait to pay it off.
+	check(50, start, 500*time.Millisecond)
+	check(50, start, time.Second)
+
+	// After 2s, the debt is paid off.
+	check(100, at(2), 0)
+
+	// Tokens don't accumulate past one second's worth.
+	check(100, at(10), 0)
+	check(100, at(10), time.Second)
+
+	// Changing the rate stops the waits, and the bucket starts full.
+	_, changed := b.reserve(0, at(10))
+	b.SetRate(0)
+	select {
+	case <-changed:
+	default:
+		t.Errorf("changing the rate did not stop the waits")
+	}
+
+	// Without a limit, we never wait.
+	check(1e9, at(10), 0)
+}
+
+func TestRateFlag(t *testing.T) {
+	cases := []struct {
+		s    string
+		rate int64
+	}{
+		{"0", 0},
+		{"100", 100},
+		{"100B/s", 100},
+		{"50M", 50 * 1024 * 1024},
+		{"50MB/s", 50 * 1024 * 1024},
+		{"2G/s", 2 * 1024 * 1024 * 1024},
+	}
+	for _, c := range cases {
+		var f RateFlag
+		if err := f.Set(c.s); err != nil || int64(f) != c.rate {
+			t.Errorf("Set(%q) = %d, %v, expected %d", c.s, f, err, c.rate)
+		}
+	}
+
+	for _, s := range []string{"", "/s", "MB/s", "-1", "50MiB/s", "50X",
+		"99999999999PB/s"} {
+		var f RateFlag
+		if err := f.Set(s); err == nil {
+			t.Errorf("Set(%q) = %d, expected error", s, f)
+		}
+	}
+}
+
+func TestLoadRates(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rates")
+	write := func(s string) {
+		if err := os.WriteFile(file, []byte(s), 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("# Comment.\n\nmax-rate = 2K/s\nmax-files-rate=1.5\n")
+	b, f, err := loadRates(file)
+	if err != nil || b != 2048 || f != 1.5 {
+		t.Errorf("got %d, %v, %v, expected 2048, 1.5, nil", b, f, err)
+	}
+
+	// Limits not in the file are removed.
+	write("max-files-rate=3\n")
+	b, f, err = loadRates(file)
+	if err != nil || b != 0 || f != 3 {
+		t.Errorf("got %d, %v, %v, expected 0, 3, nil", b, f, err)
+	}
+
+	for _, s := range []string{"lalala\n", "max-rate=x\n", "max-files-rate=-1\n"} {
+		write(s)
+		if _, _, err := loadRates(file); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
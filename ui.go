@@ -1,12 +1,23 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
+	"math"
 	"os"
 	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+
+	"golang.org/x/term"
 )
 
 var (
@@ -28,7 +39,7 @@ func Printf(format string, args ...interface{}) {
 
 func Fatalf(format string, args ...interface{}) {
 	fmt.Printf(format+"\n", args...)
-	os.Exit(1)
+	os.Exit(exitAborted)
 }
 
 func PrintVersion() {
@@ -55,20 +66,89 @@ type Progress struct {
 
 	matched, modified, missing, corrupted int64
 
+	// Files without a checksum, that we did not add (only in verify).
+	unprotected int64
+
+	// Files whose contents changed but their mtime was preserved.
+	preserved int64
+
+	// Files whose contents changed, and only their ctime did, in sealed
+	// mode (see isSuspect).
+	suspect int64
+
+	// Policy violations (modified files in sealed mode).
+	violations int64
+
+	// Files that changed while we were processing them, and were skipped.
+	changed int64
+
+	// Files that could not be read due to I/O errors.
+	unreadable int64
+
+	// Errors processing files (only in keep-going mode).
+	errors int64
+
+	// Problems found, for the end-of-run report.
+	report runReport
+
+	// Files being processed.
+	inflight map[string]*inflightFile
+
+	// Total amount of work, if known (see prescan).
+	total workTotals
+
+	// Bytes read so far. Updated atomically, as it is written to while
+	// reading the files, without holding mu.
+	bytesRead atomic.Int64
+
+	// Files we are done with, and the sum of their sizes.
+	filesDone, bytesDone int64
+
+	// Lines below the TTY status line, with the slow files (see print).
+	fileLines int
+
+	// Whether we need to clear the TTY status line before printing over it.
+	dirty bool
+
 	done chan bool
 }
 
-func NewProgress(isTTY bool) *Progress {
+// A file being processed.
+type inflightFile struct {
+	start time.Time
+	size  int64
+
+	// Bytes read so far (can be more than size if it was re-read).
+	// Updated atomically, see Progress.bytesRead.
+	read atomic.Int64
+}
+
+// Files that take longer than this to process are shown in the TTY output,
+// so it's easy to tell big files from stuck ones.
+const slowFileAge = 2 * time.Second
+
+func NewProgress(isTTY bool, total workTotals) *Progress {
 	p := &Progress{
-		start: time.Now(),
-		done:  make(chan bool),
-		isTTY: isTTY,
+		start:    time.Now(),
+		done:     make(chan bool),
+		isTTY:    isTTY,
+		inflight: map[string]*inflightFile{},
+		total:    total,
 	}
 	p.wg.Add(1)
 	go p.periodicPrint()
 	return p
 }
 
+// Set the total amount of work to do, once it is known (see -prescan). The
+// elapsed time starts counting from here, so it doesn't include the prescan.
+func (p *Progress) SetTotal(total workTotals) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total = total
+	p.start = time.Now()
+}
+
 func (p *Progress) Stop() {
 	p.done <- true
 	p.wg.Wait()
@@ -79,7 +159,8 @@ func (p *Progress) periodicPrint() {
 	ticker := time.NewTicker(250 * time.Millisecond)
 	defer ticker.Stop()
 
-	if *quiet {
+	// In JSON mode, the summary is written at the end by the caller.
+	if *quiet || options.format == formatJSONL {
 		<-p.done
 		return
 	}
@@ -117,35 +198,363 @@ func (p *Progress) print(last bool) {
 	if last {
 		suffix = "  \n"
 	}
+	if p.dirty {
+		// Clear the previous status line, and the slow files below it, as
+		// they can be longer than the new ones.
+		prefix = "\r\x1b[J"
+	}
 
 	// Usually we just overwrite the previous line.
 	// But when verbose, just print one after the other.
 	// For non-TTY, never overwrite.
+	overwrite := true
 	if *verbose || !p.isTTY {
 		prefix = ""
 		suffix = "\n"
+		overwrite = false
+	}
+
+	// The final line is just the counters, so it can be compared between
+	// runs. While in progress on a TTY, we also show the throughput.
+	status := p.statusLine()
+	if !last && p.isTTY {
+		status += p.throughput()
+	}
+	fmt.Print(prefix + status + suffix)
+
+	// Show the slow files below the status line, and then move the cursor
+	// back up to it, so we overwrite them all on the next print.
+	p.fileLines = 0
+	if !last && overwrite {
+		p.dirty = true
+		lines := p.slowFiles()
+		for _, l := range lines {
+			fmt.Print("\n" + l)
+		}
+		if len(lines) > 0 {
+			fmt.Printf("\x1b[%dA\r", len(lines))
+		}
+		p.fileLines = len(lines)
+	}
+}
+
+// Return the amount of data processed, throughput, and ETA (if the total
+// is known), to append to the status line. Must be called with p.mu held.
+func (p *Progress) throughput() string {
+	read := p.bytesRead.Load()
+	elapsed := time.Since(p.start)
+	rate := float64(read) / elapsed.Seconds()
+
+	s := ", "
+	if p.total.files > 0 {
+		s += fmt.Sprintf("%d of %d files, ", p.filesDone, p.total.files)
 	}
+	s += humanBytes(read)
+	eta := ""
+	if p.total.bytes > 0 {
+		// Done is not the same as read: skipped files count as done, and
+		// re-read files are read more than once.
+		done := p.bytesDone
+		for _, f := range p.inflight {
+			done += min(f.read.Load(), f.size)
+		}
+		done = min(done, p.total.bytes)
+
+		s += fmt.Sprintf(" of %s (%d%%)", humanBytes(p.total.bytes),
+			done*100/p.total.bytes)
+		if rate > 0 {
+			left := float64(p.total.bytes-done) / rate
+			eta = fmt.Sprintf(", ETA %v",
+				time.Duration(left*float64(time.Second)).Round(time.Second))
+		}
+	}
+	s += fmt.Sprintf(", %s/s", humanBytes(int64(rate))) + eta
+	return s
+}
+
+// Return one line for each file that has been in progress for longer than
+// slowFileAge, oldest first. Must be called with p.mu held.
+func (p *Progress) slowFiles() []string {
+	width, _, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		width = 80
+	}
+
+	lines := []string{}
+	for _, path := range p.inflightPaths(slowFileAge) {
+		l := p.fileLine(path)
+
+		// Lines must not wrap, or moving the cursor back up won't work.
+		if r := []rune(l); len(r) >= width {
+			l = string(r[:width-1])
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
 
-	fmt.Printf(
-		prefix+"%v: %d matched, %d modified, %d new, %d corrupted"+suffix,
+// Return the paths of the files that have been in progress for at least the
+// given time, oldest first. Must be called with p.mu held.
+func (p *Progress) inflightPaths(minAge time.Duration) []string {
+	paths := []string{}
+	for path, f := range p.inflight {
+		if time.Since(f.start) >= minAge {
+			paths = append(paths, path)
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return p.inflight[paths[i]].start.Before(p.inflight[paths[j]].start)
+	})
+	return paths
+}
+
+// Return a line describing the progress on the given file. Must be called
+// with p.mu held.
+func (p *Progress) fileLine(path string) string {
+	f := p.inflight[path]
+	return fmt.Sprintf("  %q: %s of %s, %v", path,
+		humanBytes(f.read.Load()), humanBytes(f.size),
+		time.Since(f.start).Round(time.Second))
+}
+
+// Format the number of bytes for humans, using binary units.
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// Return the status line, with the counters. Must be called with p.mu held.
+func (p *Progress) statusLine() string {
+	status := fmt.Sprintf(
+		"%v: %d matched, %d modified, %d new, %d corrupted",
 		time.Since(p.start).Round(time.Second),
 		p.matched, p.modified, p.missing, p.corrupted,
 	)
+	if p.preserved > 0 {
+		status += fmt.Sprintf(", %d modified with preserved mtime",
+			p.preserved)
+	}
+	if p.suspect > 0 {
+		status += fmt.Sprintf(", %d suspect", p.suspect)
+	}
+	if p.unreadable > 0 {
+		status += fmt.Sprintf(", %d unreadable", p.unreadable)
+	}
+	if p.errors > 0 {
+		status += fmt.Sprintf(", %d errors", p.errors)
+	}
+	if p.changed > 0 {
+		status += fmt.Sprintf(", %d changed during scan", p.changed)
+	}
+	if options.sealed {
+		status += fmt.Sprintf(", %d violations", p.violations)
+	}
+	if options.idle {
+		status += ", at idle priority"
+	}
+	return status
+}
+
+// Output an event about a file. In JSON mode, the event is written out;
+// otherwise the message is printed using printf (Printf or Verbosef).
+// Must be called with p.mu held.
+func (p *Progress) event(path string, ev jsonEvent,
+	printf func(string, ...interface{}), format string, args ...interface{}) {
+	if options.format == formatJSONL {
+		p.writeEvent(path, ev)
+		return
+	}
+
+	p.clear()
+	printf(format, args...)
 }
 
-func (p *Progress) PrintCorrupted(path string, expected, got ChecksumV1) {
+// Clear the TTY status line (if any), so a message can be printed instead of
+// being appended to it. It will be printed again on the next update.
+// Must be called with p.mu held.
+func (p *Progress) clear() {
+	if p.dirty {
+		fmt.Print("\r\x1b[J")
+		p.dirty = false
+	}
+}
+
+// Write the event about a file in JSON. Must be called with p.mu held.
+func (p *Progress) writeEvent(path string, ev jsonEvent) {
+	ev.Path, ev.PathB64 = jsonPath(path)
+	if f, ok := p.inflight[path]; ok {
+		ev.DurationSec = time.Since(f.start).Seconds()
+	}
+	writeJSON(ev)
+}
+
+// Keep track of the files being processed.
+func (p *Progress) StartFile(path string, size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inflight[path] = &inflightFile{start: time.Now(), size: size}
+}
+
+func (p *Progress) EndFile(path string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if f, ok := p.inflight[path]; ok {
+		p.filesDone++
+		p.bytesDone += f.size
+	}
+	delete(p.inflight, path)
+}
+
+// Return a writer that counts the bytes written to it as read from the given
+// file, for the progress output.
+func (p *Progress) ReadCounter(path string) io.Writer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f, ok := p.inflight[path]
+	if !ok {
+		f = &inflightFile{start: time.Now()}
+	}
+	return &readCounter{p, f}
+}
+
+type readCounter struct {
+	p *Progress
+	f *inflightFile
+}
+
+func (c *readCounter) Write(b []byte) (int, error) {
+	c.p.bytesRead.Add(int64(len(b)))
+	c.f.read.Add(int64(len(b)))
+	return len(b), nil
+}
+
+func (p *Progress) PrintCorrupted(path string, expected, got ChecksumV1, rr rereadResult) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	p.corrupted++
-	Printf("%q: FILE CORRUPTED - expected:%x, got:%x",
-		path, expected.CRC32C, got.CRC32C)
+	ev := jsonEvent{
+		Event:         "corrupted",
+		Old:           newJSONChecksum(expected),
+		New:           newJSONChecksum(got),
+		Rereads:       rr.n,
+		RereadsDiffer: rr.differ,
+	}
+	detail := fmt.Sprintf("expected:%x, got:%x", expected.CRC32C, got.CRC32C)
+	if rr.n > 0 {
+		detail += fmt.Sprintf(" (%v)", rr)
+	}
+	p.report.Corrupted = append(p.report.Corrupted,
+		newReportEntry(path, detail))
+	p.event(path, ev, Printf, "%q: FILE CORRUPTED - %s", path, detail)
+}
+
+func (p *Progress) PrintSuspect(path string, old, new_ ChecksumV1) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.suspect++
+	ev := jsonEvent{
+		Event: "suspect",
+		Old:   newJSONChecksum(old),
+		New:   newJSONChecksum(new_),
+	}
+	detail := fmt.Sprintf("contents changed but only the ctime did, "+
+		"possible corruption (checksum: %x -> %x, ctime: %d -> %d)",
+		old.CRC32C, new_.CRC32C, old.CTimeUsec, new_.CTimeUsec)
+	p.report.Suspect = append(p.report.Suspect, newReportEntry(path, detail))
+	p.event(path, ev, Printf, "%q: SUSPECT - %s", path, detail)
+}
+
+func (p *Progress) PrintViolation(path string, old, new_ ChecksumV1) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.violations++
+	ev := jsonEvent{
+		Event: "violation",
+		Old:   newJSONChecksum(old),
+		New:   newJSONChecksum(new_),
+	}
+	detail := fmt.Sprintf("sealed file modified "+
+		"(checksum: %x -> %x, mtime: %d -> %d)",
+		old.CRC32C, new_.CRC32C, old.ModTimeUsec, new_.ModTimeUsec)
+	if old.ModTimeUsec == new_.ModTimeUsec {
+		// The mtime tells nothing, so show what did change, like
+		// PrintPreservedMtime does.
+		detail = fmt.Sprintf("sealed file modified with preserved mtime "+
+			"(checksum: %x -> %x, size: %d -> %d)",
+			old.CRC32C, new_.CRC32C, old.Size, new_.Size)
+	}
+	p.report.Violations = append(p.report.Violations,
+		newReportEntry(path, detail))
+	p.event(path, ev, Printf, "%q: POLICY VIOLATION - %s", path, detail)
+}
+
+func (p *Progress) PrintUnreadable(path string, err *unreadableError) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.unreadable++
+	ev := jsonEvent{Event: "unreadable", Ranges: err.ranges}
+	p.report.Unreadable = append(p.report.Unreadable,
+		newReportEntry(path, err.Error()))
+	p.event(path, ev, Printf, "%q: FILE UNREADABLE - %v", path, err)
+}
+
+// Classify an error, for reporting purposes.
+func errorClass(err error) string {
+	switch {
+	case errors.Is(err, fs.ErrPermission):
+		return "permission denied"
+	case errors.Is(err, fs.ErrNotExist):
+		return "not found"
+	case errors.Is(err, errors.ErrUnsupported):
+		return "not supported"
+	case errors.Is(err, syscall.EIO):
+		return "I/O error"
+	default:
+		return "other"
+	}
+}
+
+func (p *Progress) RecordError(path string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.errors++
+	e := newReportEntry(path, err.Error())
+	e.Class = errorClass(err)
+	p.report.Errors = append(p.report.Errors, e)
+
+	// In text mode, errors are only listed in the report at the end.
+	if options.format == formatJSONL {
+		ev := jsonEvent{Event: "error", Class: e.Class, Error: e.Detail}
+		p.writeEvent(path, ev)
+	}
+}
+
+// Print the end-of-run report, in text format.
+func (p *Progress) PrintReport() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if *quiet {
+		return
+	}
+	p.report.sort()
+	p.report.writeText(os.Stdout)
 }
 
 func (p *Progress) PrintNew(path string, cs ChecksumV1) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	p.missing++
-	Verbosef("%q: writing checksum (checksum:%x, mtime:%d)",
+	ev := jsonEvent{Event: "new", New: newJSONChecksum(cs)}
+	p.event(path, ev, Verbosef,
+		"%q: writing checksum (checksum:%x, mtime:%d)",
 		path, cs.CRC32C, cs.ModTimeUsec)
 }
 
@@ -154,10 +563,18 @@ func (p *Progress) PrintMissing(path string, cs *ChecksumV1) {
 	defer p.mu.Unlock()
 	p.missing++
 	if cs == nil {
-		Verbosef("%q: missing checksum attribute", path)
+		p.unprotected++
+		if options.strict {
+			p.report.WithoutChecksums = append(p.report.WithoutChecksums,
+				newReportEntry(path, ""))
+		}
+		p.event(path, jsonEvent{Event: "new"}, Verbosef,
+			"%q: missing checksum attribute", path)
 	} else {
-		Verbosef("%q: missing checksum attribute, adding it "+
-			"(checksum:%x, mtime:%d)",
+		ev := jsonEvent{Event: "new", New: newJSONChecksum(*cs)}
+		p.event(path, ev, Verbosef,
+			"%q: missing checksum attribute, adding it "+
+				"(checksum:%x, mtime:%d)",
 			path, cs.CRC32C, cs.ModTimeUsec)
 	}
 }
@@ -166,19 +583,158 @@ func (p *Progress) PrintModified(path string, old, new_ ChecksumV1) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	p.modified++
-	Verbosef("%q: file modified (not corrupted) "+
-		"(checksum: %x -> %x, mtime: %d -> %d)",
+	ev := jsonEvent{
+		Event: "modified",
+		Old:   newJSONChecksum(old),
+		New:   newJSONChecksum(new_),
+	}
+	p.event(path, ev, Verbosef,
+		"%q: file modified (not corrupted) "+
+			"(checksum: %x -> %x, mtime: %d -> %d)",
 		path, old.CRC32C, new_.CRC32C, old.ModTimeUsec, new_.ModTimeUsec)
 }
 
+func (p *Progress) PrintPreservedMtime(path string, old, new_ ChecksumV1) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.preserved++
+	ev := jsonEvent{
+		Event: "preserved_mtime",
+		Old:   newJSONChecksum(old),
+		New:   newJSONChecksum(new_),
+	}
+	p.event(path, ev, Printf,
+		"%q: file modified with preserved mtime (not corrupted) "+
+			"(checksum: %x -> %x, size: %d -> %d)",
+		path, old.CRC32C, new_.CRC32C, old.Size, new_.Size)
+}
+
+func (p *Progress) PrintChanged(path string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.changed++
+	ev := jsonEvent{Event: "changed", Error: err.Error()}
+	p.event(path, ev, Printf, "%q: %v", path, err)
+}
+
 func (p *Progress) PrintMatched(path string, cs ChecksumV1) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	p.matched++
-	Verbosef("%q: match (checksum:%x, mtime:%d)",
+	ev := jsonEvent{Event: "matched", New: newJSONChecksum(cs)}
+	p.event(path, ev, Verbosef,
+		"%q: match (checksum:%x, mtime:%d)",
 		path, cs.CRC32C, cs.ModTimeUsec)
 }
 
+// Print the summary of the run, in JSON mode. In text mode, the final status
+// line and the exit status messages are printed instead.
+func (p *Progress) PrintJSONSummary(status *exitStatus) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.summary(status)
+	s.Event = "summary"
+	writeJSON(s)
+}
+
+// Print the current status, and the files in progress. Used to check on the
+// progress on request, e.g. when it is not a TTY (see statusSignals).
+func (p *Progress) PrintStatus() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	paths := p.inflightPaths(0)
+
+	if options.format == formatJSONL {
+		st := jsonStatus{
+			Event:        "status",
+			DurationSec:  time.Since(p.start).Seconds(),
+			jsonCounters: p.counters(),
+			BytesRead:    p.bytesRead.Load(),
+			InFlight:     []jsonInFlight{},
+		}
+		for _, path := range paths {
+			f := p.inflight[path]
+			jf := jsonInFlight{
+				Size:        f.size,
+				BytesRead:   f.read.Load(),
+				DurationSec: time.Since(f.start).Seconds(),
+			}
+			jf.Path, jf.PathB64 = jsonPath(path)
+			st.InFlight = append(st.InFlight, jf)
+		}
+		writeJSON(st)
+		return
+	}
+
+	p.clear()
+	fmt.Println(p.statusLine() + p.throughput())
+	for _, path := range paths {
+		fmt.Println(p.fileLine(path))
+	}
+}
+
+// Tell the user we are stopping, after receiving the given signal.
+func (p *Progress) PrintInterrupted(sig os.Signal) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if options.format == formatJSONL {
+		// The summary will say it was interrupted.
+		return
+	}
+	p.clear()
+	Printf("received %v signal, finishing the files in progress "+
+		"(send it again to exit immediately)", sig)
+}
+
+// Print a note about the run, that is not about a file. They are not part of
+// the JSON output.
+func (p *Progress) PrintNote(format string, args ...interface{}) {
+	p.note(Printf, format, args...)
+}
+
+// Like PrintNote, but only in verbose mode.
+func (p *Progress) PrintVerboseNote(format string, args ...interface{}) {
+	p.note(Verbosef, format, args...)
+}
+
+func (p *Progress) note(printf func(string, ...interface{}),
+	format string, args ...interface{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if options.format == formatJSONL {
+		return
+	}
+	p.clear()
+	printf(format, args...)
+}
+
+// Return the summary of the run. Must be called with p.mu held.
+func (p *Progress) summary(status *exitStatus) jsonSummary {
+	return jsonSummary{
+		DurationSec:  time.Since(p.start).Seconds(),
+		jsonCounters: p.counters(),
+		ExitCode:     status.code,
+		Messages:     status.msgs,
+		IdlePriority: options.idle,
+	}
+}
+
+// Return the counters, for the JSON output. Must be called with p.mu held.
+func (p *Progress) counters() jsonCounters {
+	return jsonCounters{
+		Matched:        p.matched,
+		Modified:       p.modified,
+		PreservedMtime: p.preserved,
+		New:            p.missing,
+		Corrupted:      p.corrupted,
+		Suspect:        p.suspect,
+		Violations:     p.violations,
+		Unreadable:     p.unreadable,
+		Changed:        p.changed,
+		Errors:         p.errors,
+	}
+}
+
 type RepeatedStringFlag []string
 
 func (f *RepeatedStringFlag) String() string {
@@ -189,3 +745,33 @@ func (f *RepeatedStringFlag) Set(value string) error {
 	*f = append(*f, value)
 	return nil
 }
+
+// Flag for an amount of bytes, which can have a K, M, G, T or P suffix (in
+// powers of 1024).
+type ByteSizeFlag int64
+
+func (f *ByteSizeFlag) String() string {
+	return fmt.Sprintf("%d", *f)
+}
+
+func (f *ByteSizeFlag) Set(value string) error {
+	mult := int64(1)
+	if i := strings.IndexAny(value, "KMGTP"); i >= 0 && i == len(value)-1 {
+		for _, c := range "KMGTP" {
+			mult *= 1024
+			if byte(c) == value[i] {
+				break
+			}
+		}
+		value = value[:i]
+	}
+
+	// Check it doesn't overflow, otherwise we could end up with a very
+	// different value (e.g. one that disables a limit).
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/mult {
+		return fmt.Errorf("invalid size %q", value)
+	}
+	*f = ByteSizeFlag(n * mult)
+	return nil
+}
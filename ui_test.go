@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestHumanBytes(t *testing.T) {
+	cases := []struct {
+		n        int64
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536, "1.5 KiB"},
+		{1024 * 1024, "1.0 MiB"},
+		{30 * 1024 * 1024 * 1024 * 1024, "30.0 TiB"},
+	}
+	for _, c := range cases {
+		if got := humanBytes(c.n); got != c.expected {
+			t.Errorf("humanBytes(%d) = %q, expected %q", c.n, got, c.expected)
+		}
+	}
+}
+
+func TestByteSizeFlag(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected int64
+	}{
+		{"0", 0},
+		{"1234", 1234},
+		{"1K", 1024},
+		{"3M", 3 * 1024 * 1024},
+		{"2G", 2 * 1024 * 1024 * 1024},
+		{"5T", 5 * 1024 * 1024 * 1024 * 1024},
+		{"1P", 1024 * 1024 * 1024 * 1024 * 1024},
+		{"8191P", 8191 * 1024 * 1024 * 1024 * 1024 * 1024},
+	}
+	for _, c := range cases {
+		var f ByteSizeFlag
+		if err := f.Set(c.value); err != nil {
+			t.Errorf("Set(%q) failed: %v", c.value, err)
+		} else if int64(f) != c.expected {
+			t.Errorf("Set(%q) = %d, expected %d", c.value, f, c.expected)
+		}
+	}
+
+	for _, value := range []string{"", "K", "1KB", "1X", "K1", "-1",
+		"8192P", "99999999999P"} {
+		var f ByteSizeFlag
+		if err := f.Set(value); err == nil {
+			t.Errorf("Set(%q) = %d, expected error", value, f)
+		}
+	}
+}
@@ -1,34 +1,54 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Returned by openAndInfo when the file was replaced between walking it and
+// opening it.
+var errReplaced = errors.New("file replaced while walking, skipped")
+
+// Returned by walk's helper when one of the limits of the run was reached
+// (see -max-duration and -max-bytes).
+var (
+	errTimeLimit = errors.New(
+		"time limit reached, not all files were processed")
+	errByteLimit = errors.New(
+		"byte limit reached, not all files were processed")
 )
 
-func openAndInfo(path string, d fs.DirEntry, err error, rootDev deviceID) (bool, *os.File, fs.FileInfo, error) {
+// Decide if the walked entry is a file we should process and, if so, return
+// its information. Used by both walk and prescan, so they agree on which files
+// to process. The subset selection is not done here, see openAndInfo.
+func selectFile(path string, d fs.DirEntry, err error, rootDev deviceID) (bool, fs.FileInfo, error) {
 	// Excluded check must come first, because it can be use to skip
 	// directories that would otherwise cause errors.
 	if isExcluded(path) {
 		if d.IsDir() {
-			return false, nil, nil, fs.SkipDir
+			return false, nil, fs.SkipDir
 		}
-		return false, nil, nil, nil
+		return false, nil, nil
 	}
 
 	if err != nil {
-		return false, nil, nil, err
+		return false, nil, err
 	}
 	if d.IsDir() || !d.Type().IsRegular() {
-		return false, nil, nil, nil
-	}
-
-	// If we are only processing a subset of the files, skip some of them.
-	if !options.subset.ShouldProcess() {
-		return false, nil, nil, nil
+		return false, nil, nil
 	}
 
 	// It is important that we obtain fs.FileInfo at this point, before
@@ -37,17 +57,54 @@ func openAndInfo(path string, d fs.DirEntry, err error, rootDev deviceID) (bool,
 	// details.
 	info, err := d.Info()
 	if err != nil {
-		return true, nil, nil, err
+		return true, nil, err
+	}
+
+	if options.oneFilesystem && rootDev != getDevice(info) {
+		return false, nil, fs.SkipDir
+	}
+
+	return true, info, nil
+}
+
+func openAndInfo(root, path string, d fs.DirEntry, err error, rootDev deviceID) (bool, *os.File, fs.FileInfo, error) {
+	ok, info, err := selectFile(path, d, err, rootDev)
+	if !ok || err != nil {
+		return ok, nil, nil, err
 	}
 
-	fd, err := os.Open(path)
+	// If we are only processing a subset of the files, skip some of them.
+	if !options.subset.ShouldProcess(root, path, info.Size()) {
+		return false, nil, nil, nil
+	}
+
+	// Open without following symlinks, and check that we opened the same file
+	// we got the information from. Otherwise, if the file was replaced in
+	// between, we would use the wrong metadata for it.
+	// Open it non-blocking too, so if it was replaced by a FIFO we don't
+	// block waiting for a writer; we clear it once we know it's the same
+	// file. Inode numbers can be reused, so compare the types as well.
+	fd, err := os.OpenFile(path,
+		os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if errors.Is(err, syscall.ELOOP) {
+		return false, nil, nil, errReplaced
+	}
 	if err != nil {
 		return true, nil, nil, err
 	}
 
-	if options.oneFilesystem && rootDev != getDevice(info) {
+	fdInfo, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return true, nil, nil, err
+	}
+	if !os.SameFile(info, fdInfo) || info.Mode().Type() != fdInfo.Mode().Type() {
+		fd.Close()
+		return false, nil, nil, errReplaced
+	}
+	if err := syscall.SetNonblock(int(fd.Fd()), false); err != nil {
 		fd.Close()
-		return false, nil, nil, fs.SkipDir
+		return true, nil, nil, err
 	}
 
 	return true, fd, info, nil
@@ -59,6 +116,10 @@ func getDevice(info fs.FileInfo) deviceID {
 	return deviceID(info.Sys().(*syscall.Stat_t).Dev)
 }
 
+func (d deviceID) String() string {
+	return fmt.Sprintf("%d:%d", unix.Major(uint64(d)), unix.Minor(uint64(d)))
+}
+
 func getDeviceForPath(path string) deviceID {
 	fi, err := os.Stat(path)
 	if err != nil {
@@ -70,79 +131,546 @@ func getDeviceForPath(path string) deviceID {
 
 type walkFn func(fd *os.File, info fs.FileInfo, p *Progress) error
 
+// A file collected while walking, to process later (see -oldest-first).
+type orderedEntry struct {
+	path         string
+	d            fs.DirEntry
+	rootIdx      int
+	rootDev      deviceID
+	lastVerified time.Time
+}
+
 type walkItem struct {
 	fd   *os.File
 	info fs.FileInfo
 	p    *Progress
+
+	// Root index and sequence number for the checkpoint (see
+	// checkpoint.send).
+	root int
+	seq  int64
+}
+
+// State of a run, shared by its walkers. Roots on different devices are walked
+// at the same time, each by its own walker (see walk).
+type walkRun struct {
+	roots []string
+	start time.Time
+	cp    *checkpoint
+	p     *Progress
+	pools *devicePools
+
+	// Closed when we are asked to stop (see handleSignals).
+	stopped <-chan struct{}
+
+	// Closed when the time limit is reached (nil if there isn't one), so we
+	// don't keep waiting for the workers after it (see -max-duration).
+	timedOut chan struct{}
+
+	// Sum of the sizes of the files sent to the workers, for -max-bytes.
+	sentBytes atomic.Int64
+
+	// First error of the walkers, which makes the others stop too.
+	mu  sync.Mutex
+	err error
 }
 
 func walk(roots []string, fn walkFn) error {
-	rootDev := deviceID(0)
-	p := NewProgress(options.isTTY)
-	defer p.Stop()
+	cp, err := newCheckpoint(options.checkpoint, roots)
+	if err != nil {
+		return err
+	}
+
+	r := &walkRun{
+		roots: roots,
+		cp:    cp,
+		p:     NewProgress(options.isTTY, workTotals{}),
+	}
+
+	// Handle signals from the start, so they work during the prescan too.
+	// If we are stopped during it, the walk stops right away.
+	stopped, stopHandling := handleSignals(r.p)
+	defer stopHandling()
+	r.stopped = stopped
 
-	// Launch the workers.
+	if options.prescan {
+		r.p.SetTotal(prescan(roots, stopped).total)
+	}
+
+	r.start = time.Now()
+	r.pools = newDevicePools(fn, cp, r.p)
+
+	cpDone := make(chan struct{})
+	cpWG := sync.WaitGroup{}
+	cpWG.Add(1)
+	go cp.saveEvery(&cpWG, checkpointInterval, cpDone)
+
+	if options.maxDuration > 0 {
+		r.timedOut = make(chan struct{})
+		timer := time.AfterFunc(options.maxDuration-time.Since(r.start), func() {
+			close(r.timedOut)
+		})
+		defer timer.Stop()
+	}
+
+	// Walk the roots of each device at the same time, so they are processed
+	// in parallel. Roots on the same device are walked one after the other.
 	wg := sync.WaitGroup{}
-	workC := make(chan walkItem)
-	workerErrs := make(chan error, options.parallel)
-	for i := 0; i < options.parallel; i++ {
+	for _, group := range groupByDevice(roots) {
 		wg.Add(1)
-		go worker(&wg, workC, fn, workerErrs)
+		go func() {
+			defer wg.Done()
+			if err := r.walkRoots(group); err != nil {
+				r.setErr(err)
+			}
+		}()
+	}
+	wg.Wait()
+	r.pools.close()
+	r.p.Stop()
+
+	// Stop the periodic saves, and wait for them, so they don't race with
+	// the final save (or removal) of the checkpoint below.
+	close(cpDone)
+	cpWG.Wait()
+
+	// Check for any errors in the last iterations.
+	err = r.getErr()
+	if werr, ok := r.pools.hasErr(); err == nil && ok {
+		err = werr
+	}
+
+	p := r.p
+	status := runStatus(p, err)
+
+	// Keep the checkpoint if the walk did not complete, so it can be resumed.
+	// Otherwise, the next run should start from scratch.
+	if err != nil {
+		err = cp.save()
+	} else {
+		err = cp.remove()
+	}
+	if err != nil {
+		status.add(exitAborted, "error saving checkpoint: %v", err)
+	}
+
+	if err := options.state.Save(); err != nil {
+		status.add(exitAborted, "error saving state: %v", err)
+	}
+
+	if options.reportFile != "" {
+		err = p.WriteReport(options.reportFile, status)
+		if err != nil {
+			status.add(exitAborted, "error writing report: %v", err)
+		}
 	}
 
+	// Print the results last, so they include any errors from saving the
+	// above.
+	if options.format == formatJSONL {
+		p.PrintJSONSummary(status)
+	} else {
+		p.PrintReport()
+	}
+
+	if status.code == 0 && len(status.msgs) == 0 {
+		return nil
+	}
+	return status
+}
+
+// Group the roots by their device, keeping their order. Returns the indexes of
+// the roots in each group.
+func groupByDevice(roots []string) [][]int {
+	// Roots we can't stat will fail as soon as they're walked, so we just
+	// keep them in order with the nearest root we know the device of.
+	devs := make([]deviceID, len(roots))
+	known := make([]bool, len(roots))
+	for i, root := range roots {
+		if fi, err := os.Stat(root); err == nil {
+			devs[i], known[i] = getDevice(fi), true
+		}
+	}
+	for i := range roots {
+		if !known[i] && i > 0 && known[i-1] {
+			devs[i], known[i] = devs[i-1], true
+		}
+	}
+	for i := len(roots) - 2; i >= 0; i-- {
+		if !known[i] && known[i+1] {
+			devs[i], known[i] = devs[i+1], true
+		}
+	}
+
+	groups := [][]int{}
+	byDev := map[deviceID]int{}
+	for i := range roots {
+		g, ok := byDev[devs[i]]
+		if !ok {
+			g = len(groups)
+			byDev[devs[i]] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+	return groups
+}
+
+func (r *walkRun) setErr(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *walkRun) getErr() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// Check if we should stop: if any of the walkers or workers had an error, if
+// we were asked to stop, or if we reached the limits.
+func (r *walkRun) checkStop() error {
+	if err := r.getErr(); err != nil {
+		return err
+	}
+	if werr, ok := r.pools.hasErr(); ok {
+		return werr
+	}
+	if isClosed(r.stopped) {
+		return errInterrupted
+	}
+	if options.maxDuration > 0 && time.Since(r.start) >= options.maxDuration {
+		return errTimeLimit
+	}
+	if options.maxBytes > 0 && r.sentBytes.Load() >= options.maxBytes {
+		return errByteLimit
+	}
+	return nil
+}
+
+// Open the file, and send it to the workers of its device.
+func (r *walkRun) process(rootIdx int, rootDev deviceID, path string, d fs.DirEntry, err error) error {
+	// Open the file one by one, because as part of doing so, the function
+	// will return fs.SkipDir as needed, so we can't parallelize it.
+	ok, fd, info, err := openAndInfo(r.roots[rootIdx], path, d, err, rootDev)
+	if errors.Is(err, errReplaced) {
+		// Not a fatal error, we just skip the file.
+		r.p.PrintChanged(path, err)
+		return nil
+	}
+	if err != nil && err != fs.SkipDir && options.keepGoing {
+		r.p.RecordError(path, err)
+		return nil
+	}
+	if !ok || err != nil {
+		return err
+	}
+
+	// Send the work to the workers. They will close the fd.
+	pool := r.pools.get(getDevice(info))
+	seq := r.cp.send(rootIdx, path)
+	select {
+	case pool.c <- walkItem{fd, info, r.p, rootIdx, seq}:
+		r.sentBytes.Add(info.Size())
+		return nil
+	case <-r.stopped:
+		r.cp.cancel(rootIdx)
+		fd.Close()
+		return errInterrupted
+	case <-r.timedOut:
+		r.cp.cancel(rootIdx)
+		fd.Close()
+		return errTimeLimit
+	}
+}
+
+// Walk the given roots (by index), one after the other.
+func (r *walkRun) walkRoots(idxs []int) error {
+	rootIdx := 0
+	rootDev := deviceID(0)
+
+	// Files to process in order of last verification (see -oldest-first).
+	// They are collected while walking, and processed after.
+	ordered := []orderedEntry{}
+
 	// Helper function used by filepath.WalkDir to send items to the workers.
 	wfn := func(path string, d fs.DirEntry, err error) error {
-		// On each iteration, check if any of the workers had an error.
-		// If so, return it, which stops the walk immediately.
-		if werr, ok := hasErr(workerErrs); ok {
-			return werr
+		// On each iteration, check if we need to stop. If so, return the
+		// error, which stops the walk immediately.
+		if err := r.checkStop(); err != nil {
+			return err
 		}
 
-		// Open the file one by one, because as part of doing so, the function
-		// will return fs.SkipDir as needed, so we can't parallelize it.
-		ok, fd, info, err := openAndInfo(path, d, err, rootDev)
-		if !ok || err != nil {
-			return err
+		// Skip what was already done, if resuming from a checkpoint.
+		// Errors are not skipped, so they are reported as usual (e.g. if
+		// the root no longer exists, d is nil).
+		isDir := d != nil && d.IsDir()
+		if err == nil && r.cp.skip(rootIdx, path, isDir) {
+			if isDir {
+				return fs.SkipDir
+			}
+			return nil
 		}
 
-		// Send the work to the workers. They will close the fd.
-		workC <- walkItem{fd, info, p}
-		return nil
+		if options.oldestFirst {
+			// Collect the files to process later, and let process handle
+			// everything else as usual (errors, skipped directories, etc.).
+			ok, _, serr := selectFile(path, d, err, rootDev)
+			if ok && serr == nil {
+				ordered = append(ordered, orderedEntry{
+					path:         path,
+					d:            d,
+					rootIdx:      rootIdx,
+					rootDev:      rootDev,
+					lastVerified: options.state.LastVerified(path),
+				})
+				return nil
+			}
+		}
+
+		return r.process(rootIdx, rootDev, path, d, err)
 	}
 
-	var err error
-	for _, root := range roots {
-		rootDev = getDeviceForPath(root)
-		err = filepath.WalkDir(root, wfn)
+	for _, i := range idxs {
+		if r.cp.skipRoot(i) {
+			continue
+		}
+		rootIdx = i
+		rootDev = getDeviceForPath(r.roots[i])
+		err := filepath.WalkDir(r.roots[i], wfn)
 		if err != nil {
-			break
+			return err
 		}
+		r.cp.walkedRoot(i)
 	}
-	close(workC)
-	wg.Wait()
 
-	// Check for any errors in the last iterations.
-	if werr, ok := hasErr(workerErrs); err == nil && ok {
-		err = werr
+	// Process the collected files, the ones verified the longest ago (or
+	// never) first.
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].lastVerified.Before(ordered[j].lastVerified)
+	})
+	for _, e := range ordered {
+		if err := r.checkStop(); err != nil {
+			return err
+		}
+		err := r.process(e.rootIdx, e.rootDev, e.path, e.d, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Workers for each device, so each one has its own concurrency (see
+// -device-parallel), and a slow device doesn't hold back the others. They are
+// started as we find files in each device.
+type devicePools struct {
+	fn walkFn
+	cp *checkpoint
+	p  *Progress
+	wg sync.WaitGroup
+
+	mu    sync.Mutex
+	pools map[deviceID]*devicePool
+}
+
+type devicePool struct {
+	c    chan walkItem
+	errs chan error
+}
+
+func newDevicePools(fn walkFn, cp *checkpoint, p *Progress) *devicePools {
+	return &devicePools{
+		fn:    fn,
+		cp:    cp,
+		p:     p,
+		pools: map[deviceID]*devicePool{},
+	}
+}
+
+// Return the pool for the device, starting its workers if needed.
+func (d *devicePools) get(dev deviceID) *devicePool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if pool, ok := d.pools[dev]; ok {
+		return pool
+	}
+
+	n, why := deviceParallel(dev)
+	if why != "" {
+		d.p.PrintVerboseNote("device %v: %d in parallel (%s)", dev, n, why)
+	}
+	pool := &devicePool{
+		c:    make(chan walkItem),
+		errs: make(chan error, n),
+	}
+	for i := 0; i < n; i++ {
+		d.wg.Add(1)
+		go worker(&d.wg, pool.c, d.fn, pool.errs, d.cp)
+	}
+	d.pools[dev] = pool
+	return pool
+}
+
+// Return the first error of the workers, if any.
+func (d *devicePools) hasErr() (error, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, pool := range d.pools {
+		if err, ok := hasErr(pool.errs); ok {
+			return err, true
+		}
+	}
+	return nil, false
+}
+
+// Stop the workers, once they are done with the files sent to them.
+func (d *devicePools) close() {
+	d.mu.Lock()
+	for _, pool := range d.pools {
+		close(pool.c)
+	}
+	d.mu.Unlock()
+	d.wg.Wait()
+}
+
+// Parse a -device-parallel value, given as path=N.
+func parseDeviceParallel(s string) (string, int, error) {
+	i := strings.LastIndex(s, "=")
+	if i <= 0 {
+		return "", 0, fmt.Errorf("invalid device parallelism %q, "+
+			"expected path=N", s)
+	}
+	n, err := strconv.Atoi(s[i+1:])
+	if err != nil || n <= 0 {
+		return "", 0, fmt.Errorf("invalid device parallelism %q, "+
+			"expected path=N with N > 0", s)
+	}
+	return s[:i], n, nil
+}
+
+// Number of files to process in parallel on the device. Unless given by the
+// user, it depends on the kind of device, and we also return why, to show it:
+// rotational disks are much slower when reading more than one file at a time,
+// while for everything else, including the devices we can't tell, we want to
+// keep the CPUs busy.
+func deviceParallel(dev deviceID) (int, string) {
+	if n, ok := options.deviceParallel[dev]; ok {
+		return n, ""
+	}
+	if options.parallel > 0 {
+		return options.parallel, ""
 	}
 
-	if p.corrupted > 0 && err == nil {
-		err = fmt.Errorf("detected %d corrupted files", p.corrupted)
+	rotational, err := isRotational(dev)
+	if err != nil {
+		return runtime.NumCPU(), fmt.Sprintf(
+			"%v, using the number of CPUs", err)
 	}
-	return err
+	if rotational {
+		return 1, "rotational"
+	}
+	return runtime.NumCPU(), "non-rotational"
 }
 
-func worker(wg *sync.WaitGroup, c chan walkItem, fn walkFn, errc chan error) {
+// Status of the run, based on the problems found (or the error that aborted
+// it).
+func runStatus(p *Progress, err error) *exitStatus {
+	status := &exitStatus{}
+	if errors.Is(err, errInterrupted) {
+		// The results so far are still valid, so we report them as usual.
+		status.add(exitInterrupted, "%v", err)
+	} else if errors.Is(err, errTimeLimit) || errors.Is(err, errByteLimit) {
+		// Stopping at the limits is expected, so it's not an error.
+		status.add(0, "%v", err)
+	} else if err != nil {
+		status.add(exitAborted, "%v", err)
+		return status
+	}
+
+	if p.corrupted > 0 {
+		status.add(exitCorrupted, "detected %d corrupted files", p.corrupted)
+	}
+	if p.suspect > 0 {
+		status.add(exitSuspect, "detected %d suspect files", p.suspect)
+	}
+	if p.unreadable > 0 {
+		status.add(exitErrors, "detected %d unreadable files", p.unreadable)
+	}
+	if p.errors > 0 {
+		status.add(exitErrors, "completed with %d errors", p.errors)
+	}
+	if p.violations > 0 {
+		status.add(exitViolations,
+			"detected %d policy violations", p.violations)
+	}
+	if p.unprotected > 0 && options.strict {
+		status.add(exitMissing,
+			"found %d files without checksums", p.unprotected)
+	}
+	return status
+}
+
+// Returned by walk when the run completed, but found problems. It has the
+// exit code to use, and messages describing the problems.
+type exitStatus struct {
+	code int
+	msgs []string
+}
+
+func (s *exitStatus) add(code int, format string, args ...interface{}) {
+	s.code |= code
+	s.msgs = append(s.msgs, fmt.Sprintf(format, args...))
+}
+
+func (s *exitStatus) Error() string {
+	return strings.Join(s.msgs, "\n")
+}
+
+func worker(wg *sync.WaitGroup, c chan walkItem, fn walkFn, errc chan error, cp *checkpoint) {
 	defer wg.Done()
 	for item := range c {
+		options.throttle.WaitFile()
+		item.p.StartFile(item.fd.Name(), item.info.Size())
 		err := fn(item.fd, item.info, item.p)
 		item.fd.Close()
-		if err != nil {
+
+		// Errors that are specific to this file are reported, and we
+		// continue with the rest.
+		aborted := false
+		var ue *unreadableError
+		if errors.Is(err, errChangedDuringScan) {
+			item.p.PrintChanged(item.fd.Name(), err)
+		} else if errors.As(err, &ue) {
+			item.p.PrintUnreadable(item.fd.Name(), ue)
+		} else if err != nil && options.keepGoing {
+			item.p.RecordError(item.fd.Name(), err)
+		} else if err != nil {
 			errc <- fmt.Errorf("error in %q: %w", item.fd.Name(), err)
+			aborted = true
+		}
+		item.p.EndFile(item.fd.Name())
+
+		// A file that aborted the run is not done, so it is left pending
+		// in the checkpoint, and processed again when resuming.
+		if !aborted {
+			cp.finish(item.root, item.seq)
 		}
 	}
 }
 
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
 func hasErr(errc chan error) (error, bool) {
 	select {
 	case err := <-errc:
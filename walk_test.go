@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestGroupByDevice(t *testing.T) {
+	dir := t.TempDir()
+	if getDeviceForPath(dir) == getDeviceForPath("/proc") {
+		t.Skip("the temporary directory is on the same device as /proc")
+	}
+
+	cases := []struct {
+		roots  []string
+		groups [][]int
+	}{
+		{[]string{dir, dir + "/.", "/proc"}, [][]int{{0, 1}, {2}}},
+		{[]string{"/proc", dir, "/proc/self"}, [][]int{{0, 2}, {1}}},
+
+		// Roots that don't exist are kept with the nearest one.
+		{[]string{"/nope", dir, "/proc"}, [][]int{{0, 1}, {2}}},
+		{[]string{dir, "/nope", "/proc"}, [][]int{{0, 1}, {2}}},
+		{[]string{"/nope", "/nope2"}, [][]int{{0, 1}}},
+	}
+	for _, c := range cases {
+		groups := groupByDevice(c.roots)
+		if !reflect.DeepEqual(groups, c.groups) {
+			t.Errorf("%q: got %v, expected %v", c.roots, groups, c.groups)
+		}
+	}
+}
+
+func TestParseDeviceParallel(t *testing.T) {
+	path, n, err := parseDeviceParallel("/a=b/c=4")
+	if path != "/a=b/c" || n != 4 || err != nil {
+		t.Errorf(`got %q, %d, %v, expected "/a=b/c", 4, nil`, path, n, err)
+	}
+
+	for _, s := range []string{"", "/a", "=4", "/a=", "/a=0", "/a=-1", "/a=x"} {
+		if path, n, err := parseDeviceParallel(s); err == nil {
+			t.Errorf("%q: got %q, %d, expected error", s, path, n)
+		}
+	}
+}
+
+func TestDeviceParallel(t *testing.T) {
+	oldParallel, oldDevParallel := options.parallel, options.deviceParallel
+	defer func() {
+		options.parallel, options.deviceParallel = oldParallel, oldDevParallel
+	}()
+
+	// /proc is not on a block device, so we can't tell its kind.
+	procDev := getDeviceForPath("/proc")
+	if _, err := isRotational(procDev); err == nil {
+		t.Errorf("expected error for the /proc device")
+	}
+
+	options.parallel = 0
+	options.deviceParallel = map[deviceID]int{}
+	if n, why := deviceParallel(procDev); n != runtime.NumCPU() || why == "" {
+		t.Errorf("automatic: got %d, %q", n, why)
+	}
+
+	options.parallel = 3
+	if n, why := deviceParallel(procDev); n != 3 || why != "" {
+		t.Errorf("-parallel: got %d, %q", n, why)
+	}
+
+	options.deviceParallel[procDev] = 5
+	if n, why := deviceParallel(procDev); n != 5 || why != "" {
+		t.Errorf("-device-parallel: got %d, %q", n, why)
+	}
+}